@@ -0,0 +1,122 @@
+package admin
+
+/**
+DetectDeadlocks looks for distributed deadlocks among GlobalLocks, using the data each client stores in its lock node
+(see session.WithIdentity) to tell which client is which.
+
+(1) Read the children of each lock root, along with their data, and sort them. The first child is the holder.
+(2) Every other child is waiting for the holder: add an edge from the waiter's identity to the holder's identity.
+(3) Search the resulting wait-for graph for cycles. A cycle means that each client in it waits for a lock held by the
+    next, so none of them can make progress.
+
+This is advisory: the locks are read one after another rather than atomically, so a cycle may have been a passing
+state, and clients whose nodes hold no data can't be told apart and are left out of the graph.
+**/
+
+import (
+	"sort"
+
+	"github.com/Shopify/gozk-recipes/session"
+)
+
+// Wait is an edge in the wait-for graph: Waiter is queued on the lock at Root,
+// which Holder holds.
+type Wait struct {
+	Waiter string
+	Holder string
+	Root   string
+}
+
+// Cycle is a chain of waits that leads back to its first waiter.
+type Cycle []Wait
+
+// DetectDeadlocks reports the cycles in the wait-for graph of the locks at
+// roots.
+func DetectDeadlocks(session *session.ZKSession, roots ...string) ([]Cycle, error) {
+	graph := make(map[string][]Wait)
+
+	for _, root := range roots {
+		// (1)
+		data, err := session.ChildrenWithData(root)
+		if err != nil {
+			return nil, err
+		}
+
+		children := make([]string, 0, len(data))
+		for child := range data {
+			children = append(children, child)
+		}
+		sort.Strings(children)
+
+		if len(children) == 0 {
+			continue
+		}
+		holder := data[children[0]]
+		if len(holder) == 0 {
+			continue
+		}
+
+		// (2)
+		for _, child := range children[1:] {
+			waiter := data[child]
+			if len(waiter) == 0 || waiter == holder {
+				continue
+			}
+			graph[waiter] = append(graph[waiter], Wait{Waiter: waiter, Holder: holder, Root: root})
+		}
+	}
+
+	// (3)
+	return findCycles(graph), nil
+}
+
+// findCycles walks the graph depth first, reporting a cycle whenever it comes
+// back to a client that is on the current path. Every client that is part of a
+// cycle is in at least one of the cycles found, though not every cycle through
+// it need be reported.
+func findCycles(graph map[string][]Wait) []Cycle {
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+
+	clients := make([]string, 0, len(graph))
+	for client := range graph {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+
+	var (
+		cycles []Cycle
+		state  = make(map[string]int)
+		path   []Wait
+		visit  func(client string)
+	)
+
+	visit = func(client string) {
+		state[client] = onPath
+		for _, wait := range graph[client] {
+			path = append(path, wait)
+			switch state[wait.Holder] {
+			case unvisited:
+				visit(wait.Holder)
+			case onPath:
+				start := len(path) - 1
+				for path[start].Waiter != wait.Holder {
+					start--
+				}
+				cycles = append(cycles, append(Cycle(nil), path[start:]...))
+			}
+			path = path[:len(path)-1]
+		}
+		state[client] = done
+	}
+
+	for _, client := range clients {
+		if state[client] == unvisited {
+			visit(client)
+		}
+	}
+	return cycles
+}
@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/gozk"
+	"github.com/Shopify/gozk-recipes/session"
+	"github.com/Shopify/gozk-recipes/test"
+)
+
+func createLockNodes(t *testing.T, s *session.ZKSession, root string, identities ...string) {
+	if err := s.EnsurePath(root); err != nil {
+		t.Fatal("EnsurePath error: ", err)
+	}
+	for _, identity := range identities {
+		if _, err := s.Create(root+"/", identity, zookeeper.EPHEMERAL|zookeeper.SEQUENCE, s.DefaultACL()); err != nil {
+			t.Fatal("Create error: ", err)
+		}
+	}
+}
+
+func TestDetectDeadlocksReportsCycle(t *testing.T) {
+	s, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+	defer s.Close()
+	s.DeleteRecursive("/test-deadlock")
+
+	createLockNodes(t, s, "/test-deadlock/first", "a", "b")
+	createLockNodes(t, s, "/test-deadlock/second", "b", "a")
+	createLockNodes(t, s, "/test-deadlock/third", "c", "a")
+
+	cycles, err := DetectDeadlocks(s, "/test-deadlock/first", "/test-deadlock/second", "/test-deadlock/third")
+	if err != nil {
+		t.Fatal("DetectDeadlocks error: ", err)
+	}
+
+	if len(cycles) != 1 || len(cycles[0]) != 2 {
+		t.Fatal("Expected one cycle of two waits, but got: ", cycles)
+	}
+	for _, wait := range cycles[0] {
+		if wait.Waiter == "c" || wait.Holder == "c" {
+			t.Error("Expected c not to be part of the cycle: ", cycles[0])
+		}
+	}
+}
+
+func TestDetectDeadlocksWithoutCycleReportsNothing(t *testing.T) {
+	s, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+	defer s.Close()
+	s.DeleteRecursive("/test-deadlock")
+
+	createLockNodes(t, s, "/test-deadlock/first", "a", "b")
+	createLockNodes(t, s, "/test-deadlock/second", "a", "b")
+
+	cycles, err := DetectDeadlocks(s, "/test-deadlock/first", "/test-deadlock/second")
+	if err != nil {
+		t.Fatal("DetectDeadlocks error: ", err)
+	}
+	if len(cycles) != 0 {
+		t.Error("Expected no cycles, but got: ", cycles)
+	}
+}
@@ -0,0 +1,138 @@
+package bench
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/gozk-recipes/lock"
+	"github.com/Shopify/gozk-recipes/session"
+)
+
+// Config describes a load test of a lock.
+type Config struct {
+	Session *session.ZKSession
+	// Root is the lock root the contenders compete for.
+	Root string
+	// Cycles is the total number of acquire/release cycles to run.
+	Cycles int
+	// Concurrency is the number of contenders, each with its own GlobalLock.
+	Concurrency int
+	// Hold is how long each contender holds the lock before releasing it.
+	Hold time.Duration
+}
+
+// Latencies summarizes the duration of a set of operations.
+type Latencies struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// Result is the outcome of a load test.
+type Result struct {
+	Cycles  int
+	Errors  int
+	Elapsed time.Duration
+	Acquire Latencies
+	Release Latencies
+}
+
+// Run acquires and releases the lock at cfg.Root cfg.Cycles times, spread over
+// cfg.Concurrency contenders, through the same Lock and Unlock calls used in
+// production. Failed cycles are counted in Errors rather than stopping the run.
+func Run(cfg Config) (*Result, error) {
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+
+	locks := make([]*lock.GlobalLock, cfg.Concurrency)
+	for i := range locks {
+		g, err := lock.NewGlobalLock(cfg.Session, cfg.Root, "")
+		if err != nil {
+			return nil, err
+		}
+		defer g.Destroy()
+		locks[i] = g
+	}
+
+	cycles := make(chan struct{}, cfg.Cycles)
+	for i := 0; i < cfg.Cycles; i++ {
+		cycles <- struct{}{}
+	}
+	close(cycles)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		acquires []time.Duration
+		releases []time.Duration
+		errors   int
+	)
+
+	start := time.Now()
+	for _, g := range locks {
+		wg.Add(1)
+		go func(g *lock.GlobalLock) {
+			defer wg.Done()
+			for range cycles {
+				acquireStart := time.Now()
+				if err := g.Lock(); err != nil {
+					mu.Lock()
+					errors++
+					mu.Unlock()
+					continue
+				}
+				acquired := time.Since(acquireStart)
+
+				time.Sleep(cfg.Hold)
+
+				releaseStart := time.Now()
+				err := g.Unlock()
+				released := time.Since(releaseStart)
+
+				mu.Lock()
+				acquires = append(acquires, acquired)
+				if err != nil {
+					errors++
+				} else {
+					releases = append(releases, released)
+				}
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	return &Result{
+		Cycles:  cfg.Cycles,
+		Errors:  errors,
+		Elapsed: time.Since(start),
+		Acquire: summarize(acquires),
+		Release: summarize(releases),
+	}, nil
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+
+func summarize(samples []time.Duration) Latencies {
+	if len(samples) == 0 {
+		return Latencies{}
+	}
+	sort.Sort(durations(samples))
+
+	percentile := func(p int) time.Duration {
+		return samples[(len(samples)-1)*p/100]
+	}
+	return Latencies{
+		P50: percentile(50),
+		P90: percentile(90),
+		P99: percentile(99),
+		Max: samples[len(samples)-1],
+	}
+}
@@ -0,0 +1,31 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/gozk-recipes/session"
+	"github.com/Shopify/gozk-recipes/test"
+)
+
+func TestRunCompletesAllCycles(t *testing.T) {
+	store, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+	defer store.Close()
+
+	store.DeleteRecursive("/test-bench")
+
+	result, err := Run(Config{Session: store, Root: "/test-bench", Cycles: 20, Concurrency: 4})
+	if err != nil {
+		t.Fatal("Run error: ", err)
+	}
+
+	if result.Errors != 0 {
+		t.Error("Expected no errors, actual: ", result.Errors)
+	}
+	if result.Acquire.Max == 0 || result.Acquire.P50 > result.Acquire.Max {
+		t.Error("Unexpected acquire latencies: ", result.Acquire)
+	}
+}
@@ -0,0 +1,490 @@
+package election
+
+/**
+Leader election follows the lock recipe: the candidate with the lowest sequence number leads, and the others wait in
+line behind it.
+
+(1) Call Create() with a pathname "{root}/candidate-" and the zookeeper.EPHEMERAL and zookeeper.SEQUENCE flags set.
+(2) Call Children() on the root. Note this is not a watch to avoid the herd effect.
+(3) If the pathname created in step 1 has the lowest sequence number, the candidate leads.
+(4) Else, call Exists() with the watch flag set on the candidate with the next lowest sequence number.
+(5) If Exists() returns false, go to step 2.
+(6) Otherwise, wait for a notification for the pathname from the previous step before going to step 2.
+
+A leader resigns by deleting the node it created in step 1, which wakes up the candidate next in line.
+
+Leadership changes are observed by watching the lowest node under the root: whenever it goes away, the next lowest node
+is the new leader. Because the session's watches are lost when it expires, an election re-reads its candidate node and
+the current leader whenever the session reconnects, rather than assuming its node still exists.
+**/
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/gozk"
+	"github.com/Shopify/gozk-recipes/session"
+)
+
+// ErrNoLeader is returned by Leader when there are no candidates.
+var ErrNoLeader = errors.New("election has no leader")
+
+// ErrResigned is returned by Campaign when Resign is called while it waits.
+var ErrResigned = errors.New("candidate resigned")
+
+// ErrCampaignInProgress is returned by Campaign when another call to it is still
+// waiting.
+var ErrCampaignInProgress = errors.New("election campaign is already in progress")
+
+const candidatePrefix = "candidate-"
+
+// The observer retries reading the election after an error, waiting between
+// minRetryInterval and maxRetryInterval.
+const (
+	minRetryInterval = 100 * time.Millisecond
+	maxRetryInterval = 5 * time.Second
+)
+
+// Event describes a change of leadership.
+type Event struct {
+	// Leader is the data of the new leader's node, or the empty string if there
+	// are no candidates left.
+	Leader string
+	// IsLeader is whether we are the new leader.
+	IsLeader bool
+}
+
+type Election struct {
+	Session *session.ZKSession
+	root    string
+	data    string
+
+	mu            sync.Mutex
+	candidatePath string
+	leader        bool
+	reset         chan struct{}
+	resigned      chan struct{}
+
+	events        chan Event
+	sessionEvents chan session.ZKSessionEvent
+	done          chan struct{}
+}
+
+// NewElection creates root if necessary. The nodeData is stored in our node
+// while we lead or wait to; if it is empty, the session's identity is used.
+func NewElection(session *session.ZKSession, root string, nodeData string) (*Election, error) {
+	if err := session.EnsureRoot(root); err != nil {
+		return nil, err
+	}
+	if nodeData == "" {
+		nodeData = session.Identity()
+	}
+
+	e := &Election{
+		Session: session,
+		root:    root,
+		data:    nodeData,
+		reset:   make(chan struct{}),
+		events:  make(chan Event, 1),
+		done:    make(chan struct{}),
+	}
+	e.subscribe()
+	go e.observe()
+
+	return e, nil
+}
+
+func (e *Election) subscribe() {
+	e.sessionEvents = make(chan session.ZKSessionEvent)
+	e.Session.Subscribe(e.sessionEvents)
+	go e.watchSession()
+}
+
+// watchSession forgets our candidacy when the session's ephemeral nodes are
+// gone, and has the current state re-read whenever the session reconnects, as
+// watches set before may never fire.
+func (e *Election) watchSession() {
+	for {
+		select {
+		case event := <-e.sessionEvents:
+			switch event {
+			case session.SessionExpiredReconnected, session.SessionFailed, session.SessionClosed:
+				e.mu.Lock()
+				e.candidatePath = ""
+				e.leader = false
+				e.mu.Unlock()
+				e.revalidate()
+			case session.SessionReconnected:
+				e.revalidate()
+			}
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// revalidate wakes up everything waiting on a watch, so that it reads the state
+// of the election again.
+func (e *Election) revalidate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	close(e.reset)
+	e.reset = make(chan struct{})
+}
+
+func (e *Election) resetSignal() <-chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.reset
+}
+
+// Destroy resigns, removes the root if no other client is using it, and stops
+// watching the session. The events channel is closed. The election must not be
+// used afterwards.
+func (e *Election) Destroy() error {
+	e.Session.Unsubscribe(e.sessionEvents)
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+
+	if err := e.Resign(); err != nil {
+		return err
+	}
+
+	children, _, err := e.Session.Children(e.root)
+	if err != nil {
+		return err
+	}
+
+	if len(children) == 0 {
+		return e.Session.Delete(e.root, -1)
+	}
+
+	return nil
+}
+
+// Events returns a channel that receives an Event whenever leadership changes,
+// starting with the leadership at the time NewElection was called. If the
+// consumer falls behind, only the latest event is kept.
+func (e *Election) Events() <-chan Event {
+	return e.events
+}
+
+// IsLeader reports whether we currently lead.
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Leader returns the data of the current leader's node, or ErrNoLeader if there
+// are no candidates.
+func (e *Election) Leader() (string, error) {
+	for {
+		children, _, err := e.Session.Children(e.root)
+		if err != nil {
+			return "", err
+		}
+		children = candidates(children)
+		if len(children) == 0 {
+			return "", ErrNoLeader
+		}
+
+		data, _, err := e.Session.Get(e.root + "/" + children[0])
+		if !zookeeper.IsError(err, zookeeper.ZNONODE) {
+			return data, err
+		}
+		// The leader resigned as we looked; try again.
+	}
+}
+
+// Campaign blocks until we lead. If ctx is done first, our node is removed, so
+// that it doesn't hold up the candidates behind us, and the context's error is
+// returned; if Resign is called instead, ErrResigned is. Only one Campaign may
+// run at a time; another call returns ErrCampaignInProgress. If our node is lost
+// along with the session while we wait, a new one is created; once we lead,
+// losing the session means losing leadership, which is reported on the events
+// channel.
+func (e *Election) Campaign(ctx context.Context) error {
+	e.mu.Lock()
+	if e.leader {
+		e.mu.Unlock()
+		return nil
+	}
+	if e.resigned != nil {
+		e.mu.Unlock()
+		return ErrCampaignInProgress
+	}
+	resigned := make(chan struct{})
+	e.resigned = resigned
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		if e.resigned == resigned {
+			e.resigned = nil
+		}
+		e.mu.Unlock()
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			e.Resign()
+			return err
+		}
+		select {
+		case <-resigned:
+			return ErrResigned
+		default:
+		}
+
+		reset := e.resetSignal()
+
+		// (1)
+		node, err := e.candidate()
+		if err != nil {
+			return err
+		}
+		select {
+		case <-resigned:
+			// Resign may have run before our node was recorded.
+			e.Resign()
+			return ErrResigned
+		default:
+		}
+
+		// (2)
+		index, predecessor, err := e.position(node)
+		if err != nil {
+			e.Resign()
+			return err
+		}
+		if index < 0 {
+			// Our node is gone, e.g. because the session expired after we
+			// created it.
+			e.forgetCandidate(node)
+			continue
+		}
+
+		// (3)
+		if index == 0 {
+			e.mu.Lock()
+			leader := path.Base(e.candidatePath) == node
+			e.leader = leader
+			e.mu.Unlock()
+			if leader {
+				return nil
+			}
+			// Our node was forgotten along with the session in the meantime.
+			continue
+		}
+
+		// (4)
+		stat, w, err := e.Session.ExistsW(e.root + "/" + predecessor)
+		if err != nil {
+			e.Resign()
+			return err
+		}
+		// (5)
+		if stat == nil {
+			continue
+		}
+		// (6)
+		select {
+		case <-w:
+		case <-reset:
+		case <-ctx.Done():
+		case <-resigned:
+		}
+	}
+}
+
+// Resign gives up leadership, or our place in line, by deleting our node. A
+// Campaign waiting in line returns ErrResigned.
+func (e *Election) Resign() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.resigned != nil {
+		close(e.resigned)
+		e.resigned = nil
+	}
+
+	if len(e.candidatePath) > 0 {
+		err := e.Session.Delete(e.candidatePath, -1)
+		if err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+			return err
+		}
+		e.Session.UntrackNode(e.candidatePath)
+		e.candidatePath = ""
+	}
+	e.leader = false
+	return nil
+}
+
+// candidate returns the base name of our node, creating it if we have none.
+func (e *Election) candidate() (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.candidatePath) == 0 {
+		candidatePath, err := e.Session.Create(e.root+"/"+candidatePrefix, e.data, zookeeper.EPHEMERAL|zookeeper.SEQUENCE, e.Session.DefaultACL())
+		if err != nil {
+			return "", err
+		}
+		e.Session.TrackNode(candidatePath)
+		e.candidatePath = candidatePath
+	}
+	return path.Base(e.candidatePath), nil
+}
+
+// forgetCandidate drops our record of node, if it is still our node, after
+// finding that ZooKeeper no longer has it.
+func (e *Election) forgetCandidate(node string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if path.Base(e.candidatePath) == node {
+		e.Session.UntrackNode(e.candidatePath)
+		e.candidatePath = ""
+		e.leader = false
+	}
+}
+
+// position returns the index of node among the sorted children of the root, or
+// -1 if it isn't among them, and the child just ahead of it, if any.
+func (e *Election) position(node string) (int, string, error) {
+	children, _, err := e.Session.Children(e.root)
+	if err != nil {
+		return 0, "", err
+	}
+	children = candidates(children)
+
+	index := sort.SearchStrings(children, node)
+	if index == len(children) || children[index] != node {
+		return -1, "", nil
+	}
+	if index == 0 {
+		return 0, "", nil
+	}
+	return index, children[index-1], nil
+}
+
+// observe publishes an Event whenever the lowest node under the root changes,
+// until the election is destroyed.
+func (e *Election) observe() {
+	defer close(e.events)
+
+	var last *Event
+	backoff := minRetryInterval
+	for {
+		reset := e.resetSignal()
+
+		event, w, err := e.current()
+		if err == nil && (last == nil || *last != event) {
+			last = &event
+			// Replace any event the consumer hasn't picked up yet.
+			select {
+			case <-e.events:
+			default:
+			}
+			e.events <- event
+		}
+
+		var retry <-chan time.Time
+		if err != nil {
+			// Try again once the session comes back, or after a while if
+			// the error had nothing to do with the session, e.g. because the
+			// root was deleted.
+			w = nil
+			retry = time.After(backoff)
+			backoff *= 2
+			if backoff > maxRetryInterval {
+				backoff = maxRetryInterval
+			}
+		} else {
+			backoff = minRetryInterval
+		}
+
+		select {
+		case <-w:
+		case <-retry:
+		case <-reset:
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// current reads the leadership and sets a watch that fires when it may have
+// changed: on the leader's node, or on the root if there are no candidates.
+func (e *Election) current() (Event, <-chan zookeeper.Event, error) {
+	for {
+		children, _, err := e.Session.Children(e.root)
+		if err != nil {
+			return Event{}, nil, err
+		}
+		children = candidates(children)
+		if len(children) == 0 {
+			var w <-chan zookeeper.Event
+			children, _, w, err = e.Session.ChildrenW(e.root)
+			if err != nil {
+				return Event{}, nil, err
+			}
+			children = candidates(children)
+			if len(children) == 0 {
+				return Event{}, w, nil
+			}
+		}
+
+		data, _, w, err := e.Session.GetW(e.root + "/" + children[0])
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			// The leader resigned as we looked; try again.
+			continue
+		}
+		if err != nil {
+			return Event{}, nil, err
+		}
+
+		e.mu.Lock()
+		candidatePath, leader := e.candidatePath, e.leader
+		e.mu.Unlock()
+
+		isLeader := len(candidatePath) > 0 && path.Base(candidatePath) == children[0]
+		if leader && !isLeader {
+			// Either we became leader after the children were listed, or our
+			// node has been deleted under us.
+			stat, err := e.Session.Exists(candidatePath)
+			if err != nil {
+				return Event{}, nil, err
+			}
+			if stat != nil {
+				continue
+			}
+			e.forgetCandidate(path.Base(candidatePath))
+		}
+
+		return Event{Leader: data, IsLeader: isLeader}, w, nil
+	}
+}
+
+// candidates returns the candidate nodes among children, sorted by sequence
+// number. Nodes created under the root by anything else are ignored, so that
+// they aren't mistaken for the leader.
+func candidates(children []string) []string {
+	result := make([]string, 0, len(children))
+	for _, child := range children {
+		if strings.HasPrefix(child, candidatePrefix) {
+			result = append(result, child)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,246 @@
+package election
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/gozk-recipes/session"
+	"github.com/Shopify/gozk-recipes/test"
+)
+
+func newTestElection(t *testing.T, data string) *Election {
+	store, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+
+	e, err := NewElection(store, "/test-election", data)
+	if err != nil {
+		t.Fatal("NewElection error: ", err)
+	}
+	return e
+}
+
+// resetTestElection removes whatever an earlier run left under the root.
+func resetTestElection(t *testing.T) {
+	store, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+	defer store.Close()
+	store.DeleteRecursive("/test-election")
+}
+
+func awaitEvent(t *testing.T, e *Election, expected Event) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-e.Events():
+			if event == expected {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Failed to receive event: ", expected)
+		}
+	}
+}
+
+func TestCampaignWinsOnceLeaderResigns(t *testing.T) {
+	resetTestElection(t)
+	first := newTestElection(t, "first")
+	defer first.Session.Close()
+	second := newTestElection(t, "second")
+	defer second.Session.Close()
+
+	if err := first.Campaign(context.Background()); err != nil {
+		t.Fatal("Campaign error: ", err)
+	}
+	awaitEvent(t, second, Event{Leader: "first"})
+
+	leader, err := second.Leader()
+	if err != nil {
+		t.Error("Leader error: ", err)
+	}
+	if leader != "first" {
+		t.Error("Expected first to lead, actual: ", leader)
+	}
+
+	won := make(chan error, 1)
+	go func() { won <- second.Campaign(context.Background()) }()
+
+	time.Sleep(100 * time.Millisecond)
+	if second.IsLeader() {
+		t.Error("Expected second not to lead while first does")
+	}
+
+	if err := first.Resign(); err != nil {
+		t.Fatal("Resign error: ", err)
+	}
+
+	select {
+	case err := <-won:
+		if err != nil {
+			t.Error("Campaign error: ", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected second to win the election")
+	}
+	awaitEvent(t, second, Event{Leader: "second", IsLeader: true})
+
+	second.Destroy()
+	first.Destroy()
+}
+
+func TestCampaignGivesUpWhenContextIsDone(t *testing.T) {
+	resetTestElection(t)
+	first := newTestElection(t, "first")
+	defer first.Session.Close()
+	second := newTestElection(t, "second")
+	defer second.Session.Close()
+
+	if err := first.Campaign(context.Background()); err != nil {
+		t.Fatal("Campaign error: ", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := second.Campaign(ctx); err != context.DeadlineExceeded {
+		t.Error("Expected context.DeadlineExceeded, but got: ", err)
+	}
+
+	children, _, err := first.Session.Children("/test-election")
+	if err != nil {
+		t.Error("Children error: ", err)
+	}
+	if len(children) != 1 {
+		t.Error("Expected only the leader's node to be left, actual: ", children)
+	}
+
+	second.Destroy()
+	first.Destroy()
+}
+
+func TestResignWithdrawsWaitingCampaign(t *testing.T) {
+	resetTestElection(t)
+	first := newTestElection(t, "first")
+	defer first.Session.Close()
+	second := newTestElection(t, "second")
+	defer second.Session.Close()
+
+	if err := first.Campaign(context.Background()); err != nil {
+		t.Fatal("Campaign error: ", err)
+	}
+
+	campaigned := make(chan error, 1)
+	go func() { campaigned <- second.Campaign(context.Background()) }()
+	time.Sleep(100 * time.Millisecond)
+
+	if err := second.Resign(); err != nil {
+		t.Fatal("Resign error: ", err)
+	}
+
+	select {
+	case err := <-campaigned:
+		if err != ErrResigned {
+			t.Error("Expected ErrResigned, but got: ", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Campaign to return after Resign")
+	}
+
+	children, _, err := first.Session.Children("/test-election")
+	if err != nil {
+		t.Error("Children error: ", err)
+	}
+	if len(children) != 1 {
+		t.Error("Expected only the leader's node to be left, actual: ", children)
+	}
+
+	second.Destroy()
+	first.Destroy()
+}
+
+func TestLeaderIgnoresNodesThatAreNotCandidates(t *testing.T) {
+	resetTestElection(t)
+	e := newTestElection(t, "leader")
+	defer e.Session.Close()
+
+	if _, err := e.Session.Create("/test-election/aaa", "", 0, e.Session.DefaultACL()); err != nil {
+		t.Fatal("Create error: ", err)
+	}
+	defer e.Session.Delete("/test-election/aaa", -1)
+
+	if err := e.Campaign(context.Background()); err != nil {
+		t.Fatal("Campaign error: ", err)
+	}
+	awaitEvent(t, e, Event{Leader: "leader", IsLeader: true})
+
+	leader, err := e.Leader()
+	if err != nil {
+		t.Error("Leader error: ", err)
+	}
+	if leader != "leader" {
+		t.Error("Expected our node to lead, actual: ", leader)
+	}
+
+	e.Resign()
+	e.Session.Delete("/test-election/aaa", -1)
+	e.Destroy()
+}
+
+func TestCampaignRefusesToRunTwiceAtOnce(t *testing.T) {
+	resetTestElection(t)
+	first := newTestElection(t, "first")
+	defer first.Session.Close()
+	second := newTestElection(t, "second")
+	defer second.Session.Close()
+
+	if err := first.Campaign(context.Background()); err != nil {
+		t.Fatal("Campaign error: ", err)
+	}
+
+	campaigned := make(chan error, 1)
+	go func() { campaigned <- second.Campaign(context.Background()) }()
+	time.Sleep(100 * time.Millisecond)
+
+	if err := second.Campaign(context.Background()); err != ErrCampaignInProgress {
+		t.Error("Expected ErrCampaignInProgress, but got: ", err)
+	}
+
+	if err := first.Resign(); err != nil {
+		t.Fatal("Resign error: ", err)
+	}
+	select {
+	case err := <-campaigned:
+		if err != nil {
+			t.Error("Campaign error: ", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the first campaign to win the election")
+	}
+
+	second.Destroy()
+	first.Destroy()
+}
+
+func TestLeadershipIsLostWhenSessionExpires(t *testing.T) {
+	resetTestElection(t)
+	e := newTestElection(t, "leader")
+	defer e.Session.Close()
+
+	if err := e.Campaign(context.Background()); err != nil {
+		t.Fatal("Campaign error: ", err)
+	}
+	awaitEvent(t, e, Event{Leader: "leader", IsLeader: true})
+
+	if err := e.Session.ForceExpire(); err != nil {
+		t.Fatal("ForceExpire error: ", err)
+	}
+	awaitEvent(t, e, Event{})
+
+	if e.IsLeader() {
+		t.Error("Expected leadership to be lost")
+	}
+	e.Destroy()
+}
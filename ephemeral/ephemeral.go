@@ -17,12 +17,21 @@ var maxWait = 20 * time.Second
 // occurred. As long as the channel has not been signalled, the caller can
 // reasonable expect that the ephemeral node still exists.
 //
+// If data is empty, the session's identity is stored in the node instead.
+//
 // This is not an appropriate construct to use for locking, as a partition will
 // not be immediately reported to the caller; the code will wait for a
 // reconnect or expiry before notifying.
 func CreateAndMaintain(z *session.ZKSession, path, data string, dead chan<- error) error {
+	if data == "" {
+		data = z.Identity()
+	}
+
 	doCreate := func() error {
-		_, err := z.Create(path, data, zookeeper.EPHEMERAL, zookeeper.WorldACL(zookeeper.PERM_ALL))
+		_, err := z.Create(path, data, zookeeper.EPHEMERAL, z.DefaultACL())
+		if err == nil {
+			z.TrackNode(path)
+		}
 		return err
 	}
 
@@ -33,7 +42,13 @@ func CreateAndMaintain(z *session.ZKSession, path, data string, dead chan<- erro
 	evs := make(chan session.ZKSessionEvent)
 	z.Subscribe(evs)
 
-	go func() { dead <- maintainEphemeral(evs, doCreate) }()
+	go func() {
+		err := maintainEphemeral(evs, doCreate)
+		// Whatever ended the maintenance, the node is gone or no longer looked
+		// after.
+		z.UntrackNode(path)
+		dead <- err
+	}()
 	return nil
 }
 
@@ -0,0 +1,156 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	nethttp "net/http"
+	"time"
+
+	"github.com/Shopify/gozk-recipes/election"
+	"github.com/Shopify/gozk-recipes/lock"
+	"github.com/Shopify/gozk-recipes/session"
+)
+
+// StatusHandler reports the state of a session, of the named locks and of the
+// named elections as JSON, e.g. when mounted at /coordination/status. While the
+// session is disconnected the locks and elections can't be read, so the handler
+// reports the status as degraded rather than failing. They are read
+// concurrently, and any that haven't been read by the time the request is done
+// are reported with the request's error.
+type StatusHandler struct {
+	Session   *session.ZKSession
+	Locks     map[string]*lock.GlobalLock
+	Elections map[string]*election.Election
+}
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+)
+
+// Status is the body written by StatusHandler.
+type Status struct {
+	Status         string                    `json:"status"`
+	Connected      bool                      `json:"connected"`
+	ConnectedSince *time.Time                `json:"connected_since,omitempty"`
+	Locks          map[string]LockStatus     `json:"locks,omitempty"`
+	Elections      map[string]ElectionStatus `json:"elections,omitempty"`
+}
+
+// LockStatus is the state of a single named lock. Waiters is the depth of the
+// queue behind the holder.
+type LockStatus struct {
+	Held       bool   `json:"held"`
+	HeldByUs   bool   `json:"held_by_us"`
+	HolderData string `json:"holder_data,omitempty"`
+	Waiters    int    `json:"waiters"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ElectionStatus is the state of a single named election. Leader is the data of
+// the leader's node, or empty if there are no candidates.
+type ElectionStatus struct {
+	IsLeader bool   `json:"is_leader"`
+	Leader   string `json:"leader,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (h *StatusHandler) ServeHTTP(w nethttp.ResponseWriter, r *nethttp.Request) {
+	status := Status{Status: StatusOK}
+
+	since, connected := h.Session.ConnectedSince()
+	status.Connected = connected
+	if connected {
+		status.ConnectedSince = &since
+	} else {
+		status.Status = StatusDegraded
+	}
+
+	if connected && len(h.Locks)+len(h.Elections) > 0 {
+		h.read(r.Context(), &status)
+		for _, lockStatus := range status.Locks {
+			if lockStatus.Error != "" {
+				status.Status = StatusDegraded
+			}
+		}
+		for _, electionStatus := range status.Elections {
+			if electionStatus.Error != "" {
+				status.Status = StatusDegraded
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// read fills in the status of every lock and election, reading them all at once
+// and giving up on those still being read once ctx is done.
+func (h *StatusHandler) read(ctx context.Context, status *Status) {
+	if len(h.Locks) > 0 {
+		status.Locks = make(map[string]LockStatus, len(h.Locks))
+	}
+	if len(h.Elections) > 0 {
+		status.Elections = make(map[string]ElectionStatus, len(h.Elections))
+	}
+
+	// Each read hands back a function recording its result, so that status is
+	// only touched here.
+	results := make(chan func(), len(h.Locks)+len(h.Elections))
+	for name, g := range h.Locks {
+		go func(name string, g *lock.GlobalLock) {
+			lockStatus := readLock(g)
+			results <- func() { status.Locks[name] = lockStatus }
+		}(name, g)
+	}
+	for name, e := range h.Elections {
+		go func(name string, e *election.Election) {
+			electionStatus := readElection(e)
+			results <- func() { status.Elections[name] = electionStatus }
+		}(name, e)
+	}
+
+	for pending := cap(results); pending > 0 && ctx.Err() == nil; {
+		select {
+		case record := <-results:
+			record()
+			pending--
+		case <-ctx.Done():
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		for name, g := range h.Locks {
+			if _, ok := status.Locks[name]; !ok {
+				status.Locks[name] = LockStatus{HeldByUs: g.Locked(), Error: err.Error()}
+			}
+		}
+		for name, e := range h.Elections {
+			if _, ok := status.Elections[name]; !ok {
+				status.Elections[name] = ElectionStatus{IsLeader: e.IsLeader(), Error: err.Error()}
+			}
+		}
+	}
+}
+
+func readLock(g *lock.GlobalLock) LockStatus {
+	lockStatus := LockStatus{HeldByUs: g.Locked()}
+	if s, err := g.Status(); err != nil {
+		lockStatus.Error = err.Error()
+	} else {
+		lockStatus.Held = s.Held
+		lockStatus.HolderData = s.HolderData
+		lockStatus.Waiters = s.Waiters
+	}
+	return lockStatus
+}
+
+func readElection(e *election.Election) ElectionStatus {
+	electionStatus := ElectionStatus{IsLeader: e.IsLeader()}
+	leader, err := e.Leader()
+	if err != nil && err != election.ErrNoLeader {
+		electionStatus.Error = err.Error()
+	}
+	electionStatus.Leader = leader
+	return electionStatus
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Shopify/gozk-recipes/election"
+	"github.com/Shopify/gozk-recipes/lock"
+	"github.com/Shopify/gozk-recipes/session"
+	"github.com/Shopify/gozk-recipes/test"
+)
+
+func serveStatus(t *testing.T, h *StatusHandler, r *nethttp.Request) Status {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Error("Expected a JSON response, actual content type: ", contentType)
+	}
+
+	var status Status
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatal("Failed to decode the response: ", err)
+	}
+	return status
+}
+
+func TestStatusHandlerReportsDegradedWhenDisconnected(t *testing.T) {
+	h := &StatusHandler{Session: &session.ZKSession{}, Locks: map[string]*lock.GlobalLock{"jobs": nil}}
+
+	status := serveStatus(t, h, httptest.NewRequest("GET", "/coordination/status", nil))
+	if status.Status != StatusDegraded {
+		t.Error("Expected the status to be degraded, actual: ", status.Status)
+	}
+	if status.Connected || status.ConnectedSince != nil {
+		t.Error("Expected the session to be reported as disconnected, actual: ", status)
+	}
+	if status.Locks != nil {
+		t.Error("Expected no lock statuses while disconnected, actual: ", status.Locks)
+	}
+}
+
+func TestStatusHandlerReportsLocksAndElections(t *testing.T) {
+	store, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+	defer store.Close()
+	store.DeleteRecursive("/test-status")
+
+	g, err := lock.NewGlobalLock(store, "/test-status", "holder")
+	if err != nil {
+		t.Fatal("NewGlobalLock error: ", err)
+	}
+	defer g.Destroy()
+	if err := g.Lock(); err != nil {
+		t.Fatal("Lock error: ", err)
+	}
+	defer g.Unlock()
+
+	store.DeleteRecursive("/test-status-election")
+	e, err := election.NewElection(store, "/test-status-election", "leader")
+	if err != nil {
+		t.Fatal("NewElection error: ", err)
+	}
+	defer e.Destroy()
+	if err := e.Campaign(context.Background()); err != nil {
+		t.Fatal("Campaign error: ", err)
+	}
+
+	h := &StatusHandler{
+		Session:   store,
+		Locks:     map[string]*lock.GlobalLock{"jobs": g},
+		Elections: map[string]*election.Election{"scheduler": e},
+	}
+
+	status := serveStatus(t, h, httptest.NewRequest("GET", "/coordination/status", nil))
+	if status.Status != StatusOK || !status.Connected || status.ConnectedSince == nil {
+		t.Error("Expected a healthy connected session, actual: ", status)
+	}
+	expected := LockStatus{Held: true, HeldByUs: true, HolderData: "holder"}
+	if status.Locks["jobs"] != expected {
+		t.Errorf("Expected lock status %v, actual %v", expected, status.Locks["jobs"])
+	}
+	expectedElection := ElectionStatus{IsLeader: true, Leader: "leader"}
+	if status.Elections["scheduler"] != expectedElection {
+		t.Errorf("Expected election status %v, actual %v", expectedElection, status.Elections["scheduler"])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("GET", "/coordination/status", nil).WithContext(ctx)
+	status = serveStatus(t, h, r)
+	if status.Status != StatusDegraded {
+		t.Error("Expected the status to be degraded once the request is done, actual: ", status.Status)
+	}
+}
@@ -0,0 +1,117 @@
+package lock
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/Shopify/gozk"
+	"github.com/Shopify/gozk-recipes/session"
+)
+
+// AuditSink receives a record of each time a GlobalLock is acquired or
+// released, along with the root and the holder data of the lock, so that a
+// single sink can be shared by several locks.
+type AuditSink interface {
+	Acquired(root, data string, at time.Time)
+	Released(root, data string, at time.Time)
+}
+
+// WithAuditSink reports every acquisition and release of the lock to sink.
+func WithAuditSink(sink AuditSink) LockOption {
+	return func(g *GlobalLock) {
+		g.audit = sink
+	}
+}
+
+const (
+	AuditAcquired = "acquired"
+	AuditReleased = "released"
+)
+
+// AuditRecord is the data stored in each node written by NodeAuditSink.
+type AuditRecord struct {
+	Event string    `json:"event"`
+	Root  string    `json:"root"`
+	Data  string    `json:"data"`
+	Time  time.Time `json:"time"`
+}
+
+// NodeAuditSink is an AuditSink that appends a sequential node under root for
+// every event, keeping at most retention of them. Recording is best effort: a
+// failure to write a record never fails the lock operation being audited.
+type NodeAuditSink struct {
+	Session   *session.ZKSession
+	root      string
+	retention int
+}
+
+// NewNodeAuditSink creates root if necessary. A retention of zero or less keeps
+// every record.
+func NewNodeAuditSink(session *session.ZKSession, root string, retention int) (*NodeAuditSink, error) {
+	if err := session.EnsureRoot(root); err != nil {
+		return nil, err
+	}
+	return &NodeAuditSink{session, root, retention}, nil
+}
+
+func (a *NodeAuditSink) Acquired(root, data string, at time.Time) {
+	a.record(AuditRecord{AuditAcquired, root, data, at})
+}
+
+func (a *NodeAuditSink) Released(root, data string, at time.Time) {
+	a.record(AuditRecord{AuditReleased, root, data, at})
+}
+
+// Records returns the retained audit records, oldest first.
+func (a *NodeAuditSink) Records() ([]AuditRecord, error) {
+	children, _, err := a.Session.Children(a.root)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(children)
+
+	records := make([]AuditRecord, 0, len(children))
+	for _, child := range children {
+		value, _, err := a.Session.Get(a.root + "/" + child)
+		if err != nil {
+			if zookeeper.IsError(err, zookeeper.ZNONODE) {
+				continue
+			}
+			return nil, err
+		}
+
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (a *NodeAuditSink) record(record AuditRecord) {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	if _, err := a.Session.Create(a.root+"/record-", string(value), zookeeper.SEQUENCE, a.Session.DefaultACL()); err != nil {
+		return
+	}
+
+	if a.retention <= 0 {
+		return
+	}
+
+	children, _, err := a.Session.Children(a.root)
+	if err != nil {
+		return
+	}
+	sort.Strings(children)
+
+	for len(children) > a.retention {
+		a.Session.Delete(a.root+"/"+children[0], -1)
+		children = children[1:]
+	}
+}
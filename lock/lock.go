@@ -19,34 +19,213 @@ Here are a few things of note:
 **/
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"path"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/Shopify/gozk"
 	"github.com/Shopify/gozk-recipes/session"
 )
 
+// ErrLockLost is returned when an operation requires the lock and we no longer
+// hold it.
+var ErrLockLost = errors.New("lock is not held")
+
+// ErrSessionUnstable is returned when the lock was created with
+// WithMinHealthyBefore and the session hasn't been connected for long enough.
+var ErrSessionUnstable = errors.New("session has not been connected for long enough to acquire the lock")
+
+// ErrNoLockNode is returned by RefreshPosition when we have no node under the
+// lock root, either because none was created yet or because it has vanished.
+var ErrNoLockNode = errors.New("lock node does not exist")
+
+// ErrRootDeleted is returned when the lock root is deleted out from under us,
+// unless the lock was created with RecreateRoot.
+var ErrRootDeleted = errors.New("lock root was deleted")
+
+// RootDeletedPolicy decides what an acquisition does when it finds that the
+// lock root has been deleted.
+type RootDeletedPolicy uint
+
+const (
+	// FailOnRootDeleted gives up with ErrRootDeleted, so that an operator
+	// deleting the root by mistake doesn't go unnoticed. This is the default.
+	FailOnRootDeleted RootDeletedPolicy = iota
+	// RecreateRoot creates the root again and starts the acquisition over.
+	RecreateRoot
+)
+
+// deleteRetryInterval is how long to wait before deleting our node again after
+// losing the connection.
+const deleteRetryInterval = 100 * time.Millisecond
+
 type GlobalLock struct {
 	Session       *session.ZKSession
 	root          string
 	ephemeralPath string
 	data          string
+
+	maxHoldDuration   time.Duration
+	audit             AuditSink
+	rootDeletedPolicy RootDeletedPolicy
+	minHealthy        time.Duration
+
+	watchdogThreshold time.Duration
+	watchdogInterval  time.Duration
+	watchdogLog       Logger
+
+	// beforeVerify is called once we have seen that ours is the lowest node,
+	// right before checking that the node still exists. Tests use it to
+	// simulate the node vanishing.
+	beforeVerify func(ephemeralPath string)
+
+	mu          sync.Mutex
+	locked      bool
+	nodeVersion int
+	epoch       int64
+	waitingOn   string
+	lost        chan struct{}
+	holdTimer   *time.Timer
+
+	stopWatchdog chan struct{}
+	reset        chan struct{}
+
+	watchMu      sync.Mutex
+	stopWatching chan struct{}
 }
 
-func NewGlobalLock(session *session.ZKSession, root string, data string) (*GlobalLock, error) {
-	if stat, _ := session.Exists(root); stat == nil {
-		_, err := session.Create(root, "", 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
-		if err != nil {
-			if stat, _ := session.Exists(root); stat == nil {
-				return nil, err
+// LockOption configures optional behaviour of a GlobalLock.
+type LockOption func(*GlobalLock)
+
+// WithMaxHoldDuration bounds how long the lock may be held. Once the duration
+// has elapsed after acquiring, our node is deleted and the LockLost channel is
+// closed, whether or not Unlock() was called.
+//
+// This is a safety valve for holders that hang or forget to unlock, not a
+// substitute for unlocking. The lock does not stop the holder's code: if it
+// keeps running past the deadline, another client may acquire the lock and two
+// holders will act at once. Pair this option with fencing tokens (see
+// FencingToken) checked by the resource being protected.
+func WithMaxHoldDuration(d time.Duration) LockOption {
+	return func(g *GlobalLock) {
+		g.maxHoldDuration = d
+	}
+}
+
+// WithRootDeletedPolicy sets what happens when the lock root is deleted while
+// we are acquiring the lock.
+func WithRootDeletedPolicy(policy RootDeletedPolicy) LockOption {
+	return func(g *GlobalLock) {
+		g.rootDeletedPolicy = policy
+	}
+}
+
+// withBeforeVerify sets the beforeVerify hook.
+func withBeforeVerify(hook func(ephemeralPath string)) LockOption {
+	return func(g *GlobalLock) {
+		g.beforeVerify = hook
+	}
+}
+
+// WithMinHealthyBefore refuses acquisition with ErrSessionUnstable unless the
+// session has been continuously connected for at least d, so that a lock isn't
+// taken on a flapping session only to be lost again straight away.
+func WithMinHealthyBefore(d time.Duration) LockOption {
+	return func(g *GlobalLock) {
+		g.minHealthy = d
+	}
+}
+
+// NewGlobalLock creates root if necessary. The data is stored in our node while
+// we hold or wait for the lock; if it is empty, the session's identity is used.
+// The lock only watches the session while it is being acquired or held, so a
+// lock that isn't needed any more can simply be dropped, or destroyed to remove
+// the root.
+func NewGlobalLock(session *session.ZKSession, root string, data string, opts ...LockOption) (*GlobalLock, error) {
+	if err := session.EnsureRoot(root); err != nil {
+		return nil, err
+	}
+	if data == "" {
+		data = session.Identity()
+	}
+	g := &GlobalLock{Session: session, root: root, data: data, reset: make(chan struct{})}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	return g, nil
+}
+
+// watch subscribes to the session, unless we already are, for as long as the
+// lock is being acquired or held.
+func (g *GlobalLock) watch() {
+	g.watchMu.Lock()
+	defer g.watchMu.Unlock()
+
+	if g.stopWatching != nil {
+		return
+	}
+	events := make(chan session.ZKSessionEvent)
+	g.stopWatching = make(chan struct{})
+	g.Session.Subscribe(events)
+	go g.watchSession(events, g.stopWatching)
+}
+
+// unwatch stops watching the session. It doesn't wait for the subscription to
+// end, so it is safe to call while an event is being delivered.
+func (g *GlobalLock) unwatch() {
+	g.watchMu.Lock()
+	defer g.watchMu.Unlock()
+
+	if g.stopWatching != nil {
+		close(g.stopWatching)
+		g.stopWatching = nil
+	}
+}
+
+// watchSession gives up a held lock when the session ends or expires, as
+// ZooKeeper has removed our node, and wakes up an acquisition waiting on a
+// watch that will never fire.
+func (g *GlobalLock) watchSession(events chan session.ZKSessionEvent, stop <-chan struct{}) {
+	for {
+		select {
+		case event := <-events:
+			switch event {
+			case session.SessionExpiredReconnected, session.SessionFailed, session.SessionClosed:
+				g.mu.Lock()
+				lost := g.lost
+				close(g.reset)
+				g.reset = make(chan struct{})
+				g.mu.Unlock()
+				g.loseHold(lost, false)
+			}
+		case <-stop:
+			// Unsubscribe waits for an event being delivered to us, so keep
+			// reading until it returns.
+			unsubscribed := make(chan struct{})
+			go func() {
+				g.Session.Unsubscribe(events)
+				close(unsubscribed)
+			}()
+			for {
+				select {
+				case <-events:
+				case <-unsubscribed:
+					return
+				}
 			}
 		}
 	}
-	return &GlobalLock{session, root, "", data}, nil
 }
 
+// Destroy removes the lock root if no other client is using it, and stops
+// watching the session. The lock must not be used afterwards.
 func (g *GlobalLock) Destroy() error {
+	g.unwatch()
+
 	children, _, err := g.Session.Children(g.root)
 	if err != nil {
 		return err
@@ -59,64 +238,661 @@ func (g *GlobalLock) Destroy() error {
 	return nil
 }
 
-func (g *GlobalLock) Lock() (err error) {
-	if len(g.ephemeralPath) > 0 {
-		if stat, _ := g.Session.Exists(g.ephemeralPath); stat != nil {
-			return nil
+// Locked reports whether we currently hold the lock.
+func (g *GlobalLock) Locked() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.locked
+}
+
+// AssertHeld checks, with a round trip to ZooKeeper, that we still hold the
+// lock, returning ErrLockLost if we don't. It is meant as a cheap check right
+// before an irreversible action taken under the lock.
+func (g *GlobalLock) AssertHeld() error {
+	g.mu.Lock()
+	locked, ephemeralPath := g.locked, g.ephemeralPath
+	g.mu.Unlock()
+
+	if !locked || len(ephemeralPath) == 0 {
+		return ErrLockLost
+	}
+
+	stat, err := g.Session.Exists(ephemeralPath)
+	if err != nil {
+		return err
+	}
+	if stat == nil {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// UpdateData replaces the data stored in our node while we hold the lock, e.g.
+// to publish progress to the clients waiting behind us. The update is checked
+// against the version of the node we last wrote, so that a node that has been
+// replaced or removed under us is detected; ErrLockLost is returned if we don't
+// hold the lock.
+func (g *GlobalLock) UpdateData(data string) error {
+	g.mu.Lock()
+	locked, ephemeralPath, version := g.locked, g.ephemeralPath, g.nodeVersion
+	g.mu.Unlock()
+
+	if !locked {
+		return ErrLockLost
+	}
+
+	stat, err := g.Session.Set(ephemeralPath, data, version)
+	if err != nil {
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			return ErrLockLost
 		}
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.locked || g.ephemeralPath != ephemeralPath {
+		return ErrLockLost
+	}
+	g.nodeVersion = stat.Version()
+	g.data = data
+	return nil
+}
+
+// claim takes the lock once our node has been seen to be the lowest. The session
+// may have flapped since the children were listed, so it first makes sure our
+// node is still there, returning false if it isn't.
+func (g *GlobalLock) claim() (bool, error) {
+	if g.beforeVerify != nil {
+		g.beforeVerify(g.ephemeralPath)
 	}
 
-	// (1)
-	g.ephemeralPath, err = g.Session.Create(g.root+"/", g.data, zookeeper.EPHEMERAL|zookeeper.SEQUENCE, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	stat, err := g.Session.Exists(g.ephemeralPath)
 	if err != nil {
+		g.abandon()
+		return false, err
+	}
+	if stat == nil {
+		g.forgetNode()
+		return false, nil
+	}
+
+	g.acquired()
+	if g.audit != nil {
+		g.audit.Acquired(g.root, g.nodeData(), time.Now())
+	}
+	return true, nil
+}
+
+// nodeData returns the data stored in our node, which UpdateData may change.
+func (g *GlobalLock) nodeData() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.data
+}
+
+// LockLost returns a channel that is closed if the current hold on the lock is
+// lost without Unlock() being called, because the session expired or ended or
+// the max hold duration elapsed. Each acquisition gets a new channel; the
+// channel is nil if the lock has never been acquired.
+func (g *GlobalLock) LockLost() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.lost
+}
+
+// WaitingOn returns the base name of the node a blocked Lock() is currently
+// watching, or the empty string if it isn't waiting on anything.
+func (g *GlobalLock) WaitingOn() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.waitingOn
+}
+
+func (g *GlobalLock) setWaitingOn(node string) {
+	g.mu.Lock()
+	g.waitingOn = node
+	g.mu.Unlock()
+}
+
+func (g *GlobalLock) Lock() error {
+	return g.lock(context.Background(), waitHooks{})
+}
+
+// LockContext is like Lock, but gives up once ctx is done, removing our node so
+// that it doesn't hold up the clients queued behind it, and returning the
+// context's error.
+func (g *GlobalLock) LockContext(ctx context.Context) error {
+	return g.lock(ctx, waitHooks{})
+}
+
+// LockWithTimeout is like LockContext, with a context that times out after
+// timeout.
+func (g *GlobalLock) LockWithTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return g.lock(ctx, waitHooks{})
+}
+
+// LockWithProgress is like Lock, but calls onProgress with our position in the
+// queue of contenders (the number of nodes ahead of ours) whenever it changes,
+// ending with zero once the lock is acquired. onProgress runs on its own
+// goroutine so that it can't hold up acquisition; if it falls behind, only the
+// latest position is delivered, and it may be called shortly after
+// LockWithProgress has returned. To notice any contender ahead of us leaving, it
+// watches the children of the root rather than just our predecessor, so it is
+// woken by every change to the queue.
+func (g *GlobalLock) LockWithProgress(onProgress func(position int)) error {
+	return g.lock(context.Background(), waitHooks{onProgress: onProgress})
+}
+
+// LockWithTicker is like Lock, but calls onTick every interval until the lock
+// is acquired, so that the caller can get on with other work (e.g. heartbeats)
+// while it waits. onTick runs on its own goroutine and ticks are skipped while
+// a previous call is still running, so a slow onTick never delays acquisition.
+// For the same reason LockWithTicker doesn't wait for a call in progress when it
+// returns, so onTick may still be running afterwards.
+func (g *GlobalLock) LockWithTicker(interval time.Duration, onTick func()) error {
+	return g.lock(context.Background(), waitHooks{onTick: onTick, tickInterval: interval})
+}
+
+// waitHooks are the optional callbacks made while an acquisition waits.
+type waitHooks struct {
+	onProgress   func(position int)
+	onTick       func()
+	tickInterval time.Duration
+}
+
+// WithLock acquires the lock, runs fn and releases the lock again, even if fn
+// panics. If ctx is done before the lock is acquired, our node is removed and
+// the context's error is returned without running fn. Otherwise fn's error is
+// returned, or the error from unlocking if fn succeeded.
+func (g *GlobalLock) WithLock(ctx context.Context, fn func() error) (err error) {
+	if err := g.lock(ctx, waitHooks{}); err != nil {
 		return err
 	}
+	defer func() {
+		if unlockErr := g.Unlock(); err == nil {
+			err = unlockErr
+		}
+	}()
 
-	var children []string
+	return fn()
+}
 
-	for {
-		// (2)
-		children, _, err = g.Session.Children(g.root)
+func (g *GlobalLock) lock(ctx context.Context, hooks waitHooks) (err error) {
+	if held, err := g.stillHeld(); held || err != nil {
+		return err
+	}
 
-		// The children nodes with be the sequence values --> 1, 2, 3....
-		sort.Strings(children)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-		if len(children) == 0 {
-			return fmt.Errorf("Lock in unknown state. Ephemeral path %s exists but there are no children.", g.ephemeralPath)
+	if err := g.checkHealthy(); err != nil {
+		return err
+	}
+
+	g.watch()
+	defer func() {
+		if err != nil {
+			g.unwatch()
 		}
+	}()
+
+	if hooks.onTick != nil && hooks.tickInterval > 0 {
+		ticker := time.NewTicker(hooks.tickInterval)
+		stop := make(chan struct{})
+		defer func() {
+			ticker.Stop()
+			close(stop)
+		}()
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					hooks.onTick()
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	report := func(position int) {}
+	if hooks.onProgress != nil {
+		progress := make(chan int, 1)
+		defer close(progress)
+		go func() {
+			for position := range progress {
+				hooks.onProgress(position)
+			}
+		}()
 
-		// (3)
-		if children[0] == path.Base(g.ephemeralPath) {
-			return nil
+		last := -1
+		report = func(position int) {
+			if position == last {
+				return
+			}
+			last = position
+			// Replace any position the callback hasn't picked up yet.
+			select {
+			case <-progress:
+			default:
+			}
+			progress <- position
 		}
+	}
 
-		myIndex := sort.SearchStrings(children, path.Base(g.ephemeralPath))
+create:
+	for {
+		// (1)
+		if err := g.createNode(); err != nil {
+			if zookeeper.IsError(err, zookeeper.ZNONODE) {
+				if err := g.rootDeleted(); err != nil {
+					return err
+				}
+				continue create
+			}
+			return err
+		}
+		node := path.Base(g.ephemeralPath)
 
 		for {
-			// (4)
-			stat, w, err := g.Session.ExistsW(g.root + "/" + children[myIndex-1])
+			// (2)
+			myIndex, predecessor, err := g.position(node)
+			if err == ErrNoLockNode {
+				// Our node is gone, e.g. because the session expired after we
+				// created it.
+				g.forgetNode()
+				continue create
+			}
 			if err != nil {
+				if zookeeper.IsError(err, zookeeper.ZNONODE) {
+					// Our node went along with the root.
+					g.forgetNode()
+					if err := g.rootDeleted(); err != nil {
+						return err
+					}
+					continue create
+				}
+				g.abandon()
 				return err
 			}
-			// (5)
-			if stat == nil {
-				break
+
+			// (3)
+			if myIndex == 0 {
+				claimed, err := g.claim()
+				if err != nil {
+					return err
+				}
+				if !claimed {
+					continue create
+				}
+				report(0)
+				return nil
+			}
+
+			report(myIndex)
+
+			g.setWaitingOn(predecessor)
+		wait:
+			for {
+				g.mu.Lock()
+				reset := g.reset
+				g.mu.Unlock()
+
+				// Waiters further ahead leaving don't touch our predecessor,
+				// so progress is followed through the children of the root.
+				var moved <-chan zookeeper.Event
+				if hooks.onProgress != nil {
+					_, _, moved, err = g.Session.ChildrenW(g.root)
+					if err != nil {
+						g.setWaitingOn("")
+						g.abandon()
+						return err
+					}
+				}
+
+				// (4)
+				stat, w, err := g.Session.ExistsW(g.root + "/" + predecessor)
+				if err != nil {
+					g.setWaitingOn("")
+					g.abandon()
+					return err
+				}
+				// (5)
+				if stat == nil {
+					g.setWaitingOn("")
+					break
+				}
+				// (6)
+				select {
+				case <-w:
+				case <-moved:
+					g.setWaitingOn("")
+					break wait
+				case <-reset:
+					// The session expired or ended, so neither our node nor the
+					// watch are any good; go back to step 2 to find out.
+					g.setWaitingOn("")
+					break wait
+				case <-ctx.Done():
+					g.setWaitingOn("")
+					g.abandon()
+					return ctx.Err()
+				}
 			}
-			// (6)
-			<-w
 		}
 	}
+}
 
+// LockStatus describes the state of a lock as seen by every client.
+type LockStatus struct {
+	// Held is whether any client holds the lock.
+	Held bool
+	// HolderData is the data stored in the holder's node.
+	HolderData string
+	// Waiters is the number of clients waiting behind the holder.
+	Waiters int
+}
+
+// Status reads who holds the lock and how many clients are waiting for it.
+func (g *GlobalLock) Status() (LockStatus, error) {
+	children, _, err := g.Session.Children(g.root)
+	if err != nil {
+		return LockStatus{}, err
+	}
+	sort.Strings(children)
+
+	for len(children) > 0 {
+		data, _, err := g.Session.Get(g.root + "/" + children[0])
+		if err == nil {
+			return LockStatus{Held: true, HolderData: data, Waiters: len(children) - 1}, nil
+		}
+		if !zookeeper.IsError(err, zookeeper.ZNONODE) {
+			return LockStatus{}, err
+		}
+		// The holder released the lock as we looked; the next in line has it.
+		children = children[1:]
+	}
+	return LockStatus{}, nil
+}
+
+// RefreshPosition re-reads the children of the lock root and reports whether
+// our existing node is the lowest, and if not, the base name of the node just
+// ahead of it. No node is created; ErrNoLockNode is returned if we don't have
+// one. This is meant for recovery flows, e.g. after an acquisition failed part
+// way.
+func (g *GlobalLock) RefreshPosition() (isLowest bool, predecessor string, err error) {
+	g.mu.Lock()
+	ephemeralPath := g.ephemeralPath
+	g.mu.Unlock()
+
+	if len(ephemeralPath) == 0 {
+		return false, "", ErrNoLockNode
+	}
+
+	index, predecessor, err := g.position(path.Base(ephemeralPath))
+	if err != nil {
+		return false, "", err
+	}
+	return index == 0, predecessor, nil
+}
+
+// position returns the index of node among the sorted children of the lock
+// root, and the child just ahead of it, if any. ErrNoLockNode is returned if
+// node isn't among the children.
+func (g *GlobalLock) position(node string) (int, string, error) {
+	children, _, err := g.Session.Children(g.root)
+	if err != nil {
+		return 0, "", err
+	}
+
+	// The children nodes with be the sequence values --> 1, 2, 3....
+	sort.Strings(children)
+
+	index := sort.SearchStrings(children, node)
+	if index == len(children) || children[index] != node {
+		return 0, "", ErrNoLockNode
+	}
+
+	if index == 0 {
+		return 0, "", nil
+	}
+	return index, children[index-1], nil
+}
+
+// stillHeld reports whether we hold the lock from an earlier acquisition. If
+// our node has been deleted under us, the hold is given up as lost. Any node
+// left behind by an attempt that failed part way is deleted, so that a new one
+// doesn't queue up behind it; if that fails, the error is returned.
+func (g *GlobalLock) stillHeld() (bool, error) {
+	g.mu.Lock()
+	locked, ephemeralPath, lost := g.locked, g.ephemeralPath, g.lost
+	g.mu.Unlock()
+
+	if locked {
+		stat, err := g.Session.Exists(ephemeralPath)
+		if err != nil {
+			return false, err
+		}
+		if stat != nil {
+			return true, nil
+		}
+		g.loseHold(lost, false)
+	} else if len(ephemeralPath) > 0 {
+		err := g.Session.Delete(ephemeralPath, -1)
+		if err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+			return false, err
+		}
+		g.dropNode(ephemeralPath)
+	}
+	return false, nil
+}
+
+// checkHealthy enforces WithMinHealthyBefore.
+func (g *GlobalLock) checkHealthy() error {
+	if g.minHealthy <= 0 {
+		return nil
+	}
+
+	since, connected := g.Session.ConnectedSince()
+	if !connected || time.Since(since) < g.minHealthy {
+		return ErrSessionUnstable
+	}
 	return nil
 }
 
+// rootDeleted applies the root deleted policy, returning ErrRootDeleted unless
+// the root was recreated.
+func (g *GlobalLock) rootDeleted() error {
+	g.Session.ForgetPath(g.root)
+	if g.rootDeletedPolicy != RecreateRoot {
+		return ErrRootDeleted
+	}
+	return g.Session.EnsurePath(g.root)
+}
+
+// createNode creates our ephemeral sequential node under the lock root.
+func (g *GlobalLock) createNode() error {
+	ephemeralPath, err := g.Session.Create(g.root+"/", g.nodeData(), zookeeper.EPHEMERAL|zookeeper.SEQUENCE, g.Session.DefaultACL())
+	if err != nil {
+		return err
+	}
+	g.Session.TrackNode(ephemeralPath)
+
+	g.mu.Lock()
+	g.ephemeralPath = ephemeralPath
+	g.nodeVersion = 0
+	g.mu.Unlock()
+	return nil
+}
+
+// forgetNode drops our record of the node of an acquisition in progress once
+// ZooKeeper no longer has it. A node that holds the lock is given up through
+// loseHold instead.
+func (g *GlobalLock) forgetNode() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.Session.UntrackNode(g.ephemeralPath)
+	g.ephemeralPath = ""
+}
+
+// abandon removes the node of an acquisition that is being given up, so that it
+// doesn't hold up the clients queued behind it.
+func (g *GlobalLock) abandon() {
+	g.mu.Lock()
+	ephemeralPath := g.ephemeralPath
+	g.mu.Unlock()
+
+	if len(ephemeralPath) == 0 {
+		return
+	}
+
+	err := g.Session.Delete(ephemeralPath, -1)
+	if err == nil || zookeeper.IsError(err, zookeeper.ZNONODE) {
+		g.dropNode(ephemeralPath)
+	}
+}
+
+// dropNode forgets ephemeralPath once it has been deleted, unless our node has
+// changed in the meantime.
+func (g *GlobalLock) dropNode(ephemeralPath string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.Session.UntrackNode(ephemeralPath)
+	if g.ephemeralPath == ephemeralPath {
+		g.ephemeralPath = ""
+	}
+}
+
+// acquired records that we hold the lock and arms the max hold timer and the
+// watchdog, if any.
+func (g *GlobalLock) acquired() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	lost := make(chan struct{})
+	g.locked = true
+	g.epoch = 0
+	g.lost = lost
+
+	if g.maxHoldDuration > 0 {
+		g.holdTimer = time.AfterFunc(g.maxHoldDuration, func() {
+			g.loseHold(lost, true)
+		})
+	}
+	g.startWatchdog()
+}
+
+// loseHold gives up the hold that is signalled through lost, if it is still the
+// current one, deleting our node unless ZooKeeper already has.
+func (g *GlobalLock) loseHold(lost chan struct{}, deleteNode bool) {
+	g.mu.Lock()
+
+	if g.lost != lost || !g.locked {
+		g.mu.Unlock()
+		return
+	}
+
+	ephemeralPath, data := g.ephemeralPath, g.data
+	if !deleteNode {
+		g.Session.UntrackNode(ephemeralPath)
+		g.ephemeralPath = ""
+	}
+	g.locked = false
+	if g.holdTimer != nil {
+		g.holdTimer.Stop()
+		g.holdTimer = nil
+	}
+	g.stopWatchdogLocked()
+	close(lost)
+	g.mu.Unlock()
+
+	g.unwatch()
+
+	if deleteNode {
+		g.deleteLostNode(ephemeralPath)
+	}
+
+	if g.audit != nil {
+		g.audit.Released(g.root, data, time.Now())
+	}
+}
+
+// deleteLostNode deletes our node after the hold on it was given up, retrying
+// while the connection is down so that the clients queued behind us aren't held
+// up. On any other error the node is left to Unlock, the next acquisition or the
+// end of the session.
+func (g *GlobalLock) deleteLostNode(ephemeralPath string) {
+	for {
+		err := g.Session.Delete(ephemeralPath, -1)
+		if err == nil || zookeeper.IsError(err, zookeeper.ZNONODE) {
+			g.dropNode(ephemeralPath)
+			return
+		}
+		if !transient(err) {
+			return
+		}
+
+		time.Sleep(deleteRetryInterval)
+
+		g.mu.Lock()
+		ours := g.ephemeralPath == ephemeralPath
+		g.mu.Unlock()
+		if !ours {
+			return
+		}
+	}
+}
+
+// transient reports whether err may go away once the session reconnects.
+func transient(err error) bool {
+	return err == session.ErrZKOperationTimeout ||
+		zookeeper.IsError(err, zookeeper.ZCONNECTIONLOSS) ||
+		zookeeper.IsError(err, zookeeper.ZOPERATIONTIMEOUT)
+}
+
+// Unlock releases the lock by deleting our node. If that fails, the lock is
+// still held, and the max hold duration, if any, still applies.
 func (g *GlobalLock) Unlock() error {
-	var err error = nil
-	if len(g.ephemeralPath) > 0 {
-		err := g.Session.Delete(g.ephemeralPath, -1)
-		if err == nil {
-			g.ephemeralPath = ""
+	g.mu.Lock()
+	ephemeralPath := g.ephemeralPath
+	g.mu.Unlock()
+
+	if len(ephemeralPath) == 0 {
+		g.unwatch()
+		return nil
+	}
+
+	err := g.Session.Delete(ephemeralPath, -1)
+	if err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+		return err
+	}
+
+	g.mu.Lock()
+	released := false
+	g.Session.UntrackNode(ephemeralPath)
+	if g.ephemeralPath == ephemeralPath {
+		g.ephemeralPath = ""
+		released = g.locked
+		g.locked = false
+		if g.holdTimer != nil {
+			g.holdTimer.Stop()
+			g.holdTimer = nil
 		}
+		g.stopWatchdogLocked()
 	}
-	return err
+	data := g.data
+	g.mu.Unlock()
+
+	g.unwatch()
+
+	if released && g.audit != nil {
+		g.audit.Released(g.root, data, time.Now())
+	}
+	return nil
 }
@@ -0,0 +1,864 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/gozk-recipes/session"
+	"github.com/Shopify/gozk-recipes/test"
+)
+
+func withTestLock(t testing.TB, f func(*GlobalLock)) {
+	store, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+	defer store.Close()
+
+	store.DeleteRecursive("/test-lock")
+
+	g, err := NewGlobalLock(store, "/test-lock", "holder")
+	if err != nil {
+		t.Fatal("NewGlobalLock error: ", err)
+	}
+	defer g.Destroy()
+
+	f(g)
+}
+
+func TestLockLostFiresWhenSessionExpires(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		if err := g.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+
+		if err := g.Session.ForceExpire(); err != nil {
+			t.Fatal("ForceExpire error: ", err)
+		}
+
+		select {
+		case <-g.LockLost():
+		case <-time.After(10 * time.Second):
+			t.Fatal("Expected LockLost to fire after the session expired")
+		}
+
+		if g.Locked() {
+			t.Error("Expected the lock to no longer be held")
+		}
+	})
+}
+
+func TestLockRetriesWhenNodeVanishesBeforeVerification(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		var vanished string
+		withBeforeVerify(func(ephemeralPath string) {
+			if vanished == "" {
+				vanished = ephemeralPath
+				if err := g.Session.Delete(ephemeralPath, -1); err != nil {
+					t.Error("Delete error: ", err)
+				}
+			}
+		})(g)
+
+		if err := g.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+		defer g.Unlock()
+
+		if !g.Locked() {
+			t.Error("Expected the lock to be held")
+		}
+		if err := g.AssertHeld(); err != nil {
+			t.Error("AssertHeld error: ", err)
+		}
+		if g.ephemeralPath == vanished {
+			t.Error("Expected the lock to be acquired with a new node")
+		}
+	})
+}
+
+func TestLockGivesUpHoldWhenNodeWasDeletedUnderUs(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		if err := g.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+		lost := g.LockLost()
+
+		g.mu.Lock()
+		ephemeralPath := g.ephemeralPath
+		g.mu.Unlock()
+		if err := g.Session.Delete(ephemeralPath, -1); err != nil {
+			t.Fatal("Delete error: ", err)
+		}
+
+		if err := g.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+		defer g.Unlock()
+
+		select {
+		case <-lost:
+		default:
+			t.Error("Expected the earlier hold to be reported lost")
+		}
+		if g.LockLost() == lost {
+			t.Error("Expected the lock to be acquired again with a new hold")
+		}
+	})
+}
+
+func lockAfterRootDeleted(t *testing.T, policy RootDeletedPolicy) error {
+	var result error
+	withTestLock(t, func(holder *GlobalLock) {
+		if err := holder.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+
+		waiter, err := NewGlobalLock(holder.Session, "/test-lock", "waiter", WithRootDeletedPolicy(policy))
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+		defer waiter.Destroy()
+
+		done := make(chan error, 1)
+		go func() { done <- waiter.Lock() }()
+
+		awaitWaiting(t, waiter)
+
+		if err := holder.Session.DeleteRecursive("/test-lock"); err != nil {
+			t.Fatal("DeleteRecursive error: ", err)
+		}
+
+		select {
+		case result = <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected Lock to return after the root was deleted")
+		}
+		waiter.Unlock()
+	})
+	return result
+}
+
+func TestLockFailsFastWhenRootIsDeleted(t *testing.T) {
+	if err := lockAfterRootDeleted(t, FailOnRootDeleted); err != ErrRootDeleted {
+		t.Error("Expected ErrRootDeleted, but got: ", err)
+	}
+}
+
+func TestLockRecreatesRootWhenConfigured(t *testing.T) {
+	if err := lockAfterRootDeleted(t, RecreateRoot); err != nil {
+		t.Error("Expected the lock to be acquired after recreating the root, but got: ", err)
+	}
+}
+
+func TestNewGlobalLockRecreatesRootDestroyedByAnotherClient(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		other, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+		if err != nil {
+			t.Fatal("Failed to connect to Zookeeper: ", err)
+		}
+		defer other.Close()
+
+		destroyed, err := NewGlobalLock(other, "/test-lock", "other")
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+		if err := destroyed.Destroy(); err != nil {
+			t.Fatal("Destroy error: ", err)
+		}
+
+		recreated, err := NewGlobalLock(g.Session, "/test-lock", "holder")
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+		defer recreated.Destroy()
+
+		if err := recreated.Lock(); err != nil {
+			t.Error("Lock error: ", err)
+		}
+		recreated.Unlock()
+	})
+}
+
+func TestRefreshPositionReportsOurPosition(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		if _, _, err := g.RefreshPosition(); err != ErrNoLockNode {
+			t.Error("Expected ErrNoLockNode, but got: ", err)
+		}
+
+		if err := g.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+		defer g.Unlock()
+
+		isLowest, predecessor, err := g.RefreshPosition()
+		if err != nil {
+			t.Error("RefreshPosition error: ", err)
+		}
+		if !isLowest || predecessor != "" {
+			t.Errorf("Expected to be lowest with no predecessor, actual %v and %q", isLowest, predecessor)
+		}
+	})
+}
+
+func TestLockRefusesOnSessionConnectedTooRecently(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		unstable, err := NewGlobalLock(g.Session, "/test-lock", "", WithMinHealthyBefore(time.Hour))
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+		defer unstable.Destroy()
+
+		if err := unstable.Lock(); err != ErrSessionUnstable {
+			t.Error("Expected ErrSessionUnstable, but got: ", err)
+		}
+	})
+}
+
+func TestLockWithEpochRefusesOlderEpoch(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		delete(fences, "/test-lock")
+
+		if err := g.LockWithEpoch(2); err != nil {
+			t.Fatal("LockWithEpoch error: ", err)
+		}
+		token, err := g.FencingToken()
+		if err != nil {
+			t.Error("FencingToken error: ", err)
+		}
+		if g.Epoch() != 2 {
+			t.Error("Expected epoch 2, actual: ", g.Epoch())
+		}
+		g.Unlock()
+
+		if _, err := g.FencingToken(); err != ErrLockLost {
+			t.Error("Expected ErrLockLost, but got: ", err)
+		}
+
+		if err := g.LockWithEpoch(1); err != ErrStaleEpoch {
+			t.Error("Expected ErrStaleEpoch, but got: ", err)
+		}
+		if g.Locked() {
+			t.Error("Expected the lock not to be held")
+		}
+
+		if err := g.LockWithEpoch(2); err != nil {
+			t.Fatal("LockWithEpoch error: ", err)
+		}
+		defer g.Unlock()
+
+		next, err := g.FencingToken()
+		if err != nil {
+			t.Error("FencingToken error: ", err)
+		}
+		if next <= token {
+			t.Errorf("Expected fencing token to increase from %d, actual %d", token, next)
+		}
+	})
+}
+
+func TestTryLockFailsWithoutLeavingNodeWhenHeld(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		if err := g.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+		defer g.Unlock()
+
+		other, err := NewGlobalLock(g.Session, "/test-lock", "other")
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+		defer other.Destroy()
+
+		acquired, err := other.TryLock()
+		if err != nil {
+			t.Error("TryLock error: ", err)
+		}
+		if acquired {
+			t.Error("Expected TryLock not to acquire a held lock")
+		}
+
+		children, _, err := g.Session.Children("/test-lock")
+		if err != nil {
+			t.Error("Children error: ", err)
+		}
+		if len(children) != 1 {
+			t.Error("Expected only the holder's node to be left, actual: ", children)
+		}
+	})
+}
+
+func TestTryAnyReturnsFirstFreeLockWithoutLeavingNodes(t *testing.T) {
+	withTestLock(t, func(holder *GlobalLock) {
+		if err := holder.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+
+		busy, err := NewGlobalLock(holder.Session, "/test-lock", "busy")
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+		holder.Session.DeleteRecursive("/test-lock-free")
+		free, err := NewGlobalLock(holder.Session, "/test-lock-free", "free")
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+		holder.Session.DeleteRecursive("/test-lock-untried")
+		untried, err := NewGlobalLock(holder.Session, "/test-lock-untried", "untried")
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+
+		acquired, err := TryAny(busy, free, untried)
+		if err != nil {
+			t.Error("TryAny error: ", err)
+		}
+		if acquired != free {
+			t.Fatal("Expected the first free lock to be acquired")
+		}
+
+		children, _, err := holder.Session.Children("/test-lock")
+		if err != nil {
+			t.Error("Children error: ", err)
+		}
+		if len(children) != 1 {
+			t.Error("Expected only the holder's node to be left, actual: ", children)
+		}
+		children, _, err = holder.Session.Children("/test-lock-untried")
+		if err != nil {
+			t.Error("Children error: ", err)
+		}
+		if len(children) != 0 {
+			t.Error("Expected no node for a lock that wasn't tried, actual: ", children)
+		}
+
+		free.Unlock()
+		holder.Unlock()
+		untried.Destroy()
+		free.Destroy()
+	})
+}
+
+// awaitWaiting waits for g to be blocked behind another node.
+func awaitWaiting(t *testing.T, g *GlobalLock) {
+	deadline := time.After(5 * time.Second)
+	for g.WaitingOn() == "" {
+		select {
+		case <-deadline:
+			t.Fatal("Expected the lock to be waiting")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func assertPosition(t *testing.T, positions <-chan int, expected int) {
+	select {
+	case position := <-positions:
+		if position != expected {
+			t.Errorf("Expected position %d, actual %d", expected, position)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected position: ", expected)
+	}
+}
+
+func TestLockWithProgressReportsWaitersAheadLeaving(t *testing.T) {
+	withTestLock(t, func(holder *GlobalLock) {
+		if err := holder.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+
+		var cancels []context.CancelFunc
+		for _, data := range []string{"first", "second"} {
+			g, err := NewGlobalLock(holder.Session, "/test-lock", data)
+			if err != nil {
+				t.Fatal("NewGlobalLock error: ", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cancels = append(cancels, cancel)
+			go g.LockContext(ctx)
+			awaitWaiting(t, g)
+		}
+
+		waiter, err := NewGlobalLock(holder.Session, "/test-lock", "waiter")
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+		positions := make(chan int, 10)
+		locked := make(chan error, 1)
+		go func() {
+			locked <- waiter.LockWithProgress(func(position int) { positions <- position })
+		}()
+		assertPosition(t, positions, 3)
+
+		// The first waiter isn't our predecessor.
+		cancels[0]()
+		assertPosition(t, positions, 2)
+
+		cancels[1]()
+		assertPosition(t, positions, 1)
+
+		holder.Unlock()
+		assertPosition(t, positions, 0)
+
+		if err := <-locked; err != nil {
+			t.Error("LockWithProgress error: ", err)
+		}
+		waiter.Unlock()
+	})
+}
+
+func TestLockWithProgressReportsQueuePosition(t *testing.T) {
+	withTestLock(t, func(holder *GlobalLock) {
+		if err := holder.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+
+		waiter, err := NewGlobalLock(holder.Session, "/test-lock", "waiter")
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+
+		positions := make(chan int, 10)
+		locked := make(chan error, 1)
+		go func() {
+			locked <- waiter.LockWithProgress(func(position int) { positions <- position })
+		}()
+
+		for _, expected := range []int{1, 0} {
+			select {
+			case position := <-positions:
+				if position != expected {
+					t.Errorf("Expected position %d, actual %d", expected, position)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Expected position: ", expected)
+			}
+			if expected == 1 {
+				holder.Unlock()
+			}
+		}
+
+		if err := <-locked; err != nil {
+			t.Error("LockWithProgress error: ", err)
+		}
+		waiter.Unlock()
+	})
+}
+
+func TestLockWithTickerTicksOnlyWhileWaiting(t *testing.T) {
+	withTestLock(t, func(holder *GlobalLock) {
+		if err := holder.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+
+		waiter, err := NewGlobalLock(holder.Session, "/test-lock", "waiter")
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+
+		var mu sync.Mutex
+		ticks := 0
+		tick := func() {
+			mu.Lock()
+			ticks++
+			mu.Unlock()
+		}
+		ticked := func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return ticks
+		}
+
+		locked := make(chan error, 1)
+		go func() { locked <- waiter.LockWithTicker(20*time.Millisecond, tick) }()
+
+		time.Sleep(200 * time.Millisecond)
+		holder.Unlock()
+
+		select {
+		case err := <-locked:
+			if err != nil {
+				t.Error("LockWithTicker error: ", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the waiter to acquire the lock")
+		}
+
+		if ticked() == 0 {
+			t.Error("Expected onTick to be called while waiting")
+		}
+		// Let a tick in progress as we acquired through.
+		time.Sleep(10 * time.Millisecond)
+		after := ticked()
+		time.Sleep(100 * time.Millisecond)
+		if ticked() != after {
+			t.Error("Expected onTick not to be called once the lock is acquired")
+		}
+		waiter.Unlock()
+	})
+}
+
+func TestLockContextRemovesNodeWhenContextIsDone(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		if err := g.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+		defer g.Unlock()
+
+		other, err := NewGlobalLock(g.Session, "/test-lock", "other")
+		if err != nil {
+			t.Fatal("NewGlobalLock error: ", err)
+		}
+		defer other.Destroy()
+
+		if err := other.LockWithTimeout(100 * time.Millisecond); err != context.DeadlineExceeded {
+			t.Error("Expected context.DeadlineExceeded, but got: ", err)
+		}
+
+		children, _, err := g.Session.Children("/test-lock")
+		if err != nil {
+			t.Error("Children error: ", err)
+		}
+		if len(children) != 1 {
+			t.Error("Expected only the holder's node to be left, actual: ", children)
+		}
+	})
+}
+
+func TestMaxHoldDurationGivesUpTheLock(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		WithMaxHoldDuration(100 * time.Millisecond)(g)
+
+		if err := g.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+		g.mu.Lock()
+		ephemeralPath := g.ephemeralPath
+		g.mu.Unlock()
+
+		select {
+		case <-g.LockLost():
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected LockLost to fire once the max hold duration elapsed")
+		}
+
+		if err := g.AssertHeld(); err != ErrLockLost {
+			t.Error("Expected ErrLockLost, but got: ", err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			stat, err := g.Session.Exists(ephemeralPath)
+			if err != nil {
+				t.Fatal("Exists error: ", err)
+			}
+			if stat == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Expected our node to be deleted")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestNodeAuditSinkRecordsAcquisitionsAndReleases(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		g.Session.DeleteRecursive("/test-lock-audit")
+		sink, err := NewNodeAuditSink(g.Session, "/test-lock-audit", 3)
+		if err != nil {
+			t.Fatal("NewNodeAuditSink error: ", err)
+		}
+		defer g.Session.DeleteRecursive("/test-lock-audit")
+		WithAuditSink(sink)(g)
+
+		for i := 0; i < 2; i++ {
+			if err := g.Lock(); err != nil {
+				t.Fatal("Lock error: ", err)
+			}
+			if err := g.Unlock(); err != nil {
+				t.Fatal("Unlock error: ", err)
+			}
+		}
+
+		records, err := sink.Records()
+		if err != nil {
+			t.Fatal("Records error: ", err)
+		}
+		// The first acquisition has been pruned.
+		expected := []string{AuditReleased, AuditAcquired, AuditReleased}
+		if len(records) != len(expected) {
+			t.Fatal("Expected 3 records to be retained, actual: ", records)
+		}
+		for i, record := range records {
+			if record.Event != expected[i] {
+				t.Errorf("Expected record %d to be %q, actual %q", i, expected[i], record.Event)
+			}
+			if record.Root != "/test-lock" || record.Data != "holder" {
+				t.Error("Expected the record to name the lock and its holder, actual: ", record)
+			}
+			if i > 0 && record.Time.Before(records[i-1].Time) {
+				t.Error("Expected records oldest first, actual: ", records)
+			}
+		}
+	})
+}
+
+func TestWithLockReleasesLockWhenFnPanics(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		var ephemeralPath string
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected the panic to be passed on")
+				}
+			}()
+			g.WithLock(context.Background(), func() error {
+				g.mu.Lock()
+				ephemeralPath = g.ephemeralPath
+				g.mu.Unlock()
+				panic("fn failed")
+			})
+		}()
+
+		if g.Locked() {
+			t.Error("Expected the lock to be released")
+		}
+		stat, err := g.Session.Exists(ephemeralPath)
+		if err != nil {
+			t.Error("Exists error: ", err)
+		}
+		if stat != nil {
+			t.Error("Expected the lock node to be deleted")
+		}
+	})
+}
+
+// recordingLogger counts the lines logged through it.
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines int
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines++
+}
+
+func (l *recordingLogger) logged() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lines
+}
+
+func TestWatchdogLogsOnceThresholdIsExceeded(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		logger := &recordingLogger{}
+		WithWatchdog(50*time.Millisecond, 50*time.Millisecond, logger)(g)
+
+		if err := g.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+		if err := g.Unlock(); err != nil {
+			t.Error("Unlock error: ", err)
+		}
+
+		// Let a line being logged as we unlocked through.
+		time.Sleep(10 * time.Millisecond)
+		logged := logger.logged()
+		if logged == 0 {
+			t.Error("Expected the watchdog to log a long-held lock")
+		}
+		time.Sleep(100 * time.Millisecond)
+		if logger.logged() != logged {
+			t.Error("Expected the watchdog to stop once the lock is released")
+		}
+	})
+}
+
+func TestWatchdogDoesNotLogLockReleasedInTime(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		logger := &recordingLogger{}
+		WithWatchdog(200*time.Millisecond, 0, logger)(g)
+
+		if err := g.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+		if err := g.Unlock(); err != nil {
+			t.Error("Unlock error: ", err)
+		}
+
+		time.Sleep(300 * time.Millisecond)
+		if logger.logged() != 0 {
+			t.Error("Expected the watchdog not to log a lock released in time")
+		}
+	})
+}
+
+func TestUpdateDataReplacesNodeDataWhileHeld(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		if err := g.UpdateData("progress"); err != ErrLockLost {
+			t.Error("Expected ErrLockLost before locking, but got: ", err)
+		}
+
+		if err := g.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+		if err := g.UpdateData("progress"); err != nil {
+			t.Error("UpdateData error: ", err)
+		}
+
+		data, _, err := g.Session.Get(g.ephemeralPath)
+		if err != nil {
+			t.Error("Get error: ", err)
+		}
+		if data != "progress" {
+			t.Error("Expected the node to hold the new data, actual: ", data)
+		}
+
+		if err := g.Unlock(); err != nil {
+			t.Error("Unlock error: ", err)
+		}
+		if err := g.UpdateData("done"); err != ErrLockLost {
+			t.Error("Expected ErrLockLost after unlocking, but got: ", err)
+		}
+	})
+}
+
+func TestRWLockSharesReadsAndExcludesWrites(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		first, err := NewRWLock(g.Session, "/test-rwlock", "first")
+		if err != nil {
+			t.Fatal("NewRWLock error: ", err)
+		}
+		second, err := NewRWLock(g.Session, "/test-rwlock", "second")
+		if err != nil {
+			t.Fatal("NewRWLock error: ", err)
+		}
+		writer, err := NewRWLock(g.Session, "/test-rwlock", "writer")
+		if err != nil {
+			t.Fatal("NewRWLock error: ", err)
+		}
+
+		if err := first.RLock(); err != nil {
+			t.Fatal("RLock error: ", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := second.RLockContext(ctx); err != nil {
+			t.Fatal("Expected readers to share the lock: ", err)
+		}
+
+		locked := make(chan error, 1)
+		go func() { locked <- writer.Lock() }()
+
+		time.Sleep(100 * time.Millisecond)
+		select {
+		case <-locked:
+			t.Fatal("Expected the writer to wait for the readers")
+		default:
+		}
+
+		first.RUnlock()
+		second.RUnlock()
+
+		select {
+		case err := <-locked:
+			if err != nil {
+				t.Error("Lock error: ", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the writer to acquire the lock")
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := first.RLockContext(ctx); err != context.DeadlineExceeded {
+			t.Error("Expected the reader to wait for the writer, but got: ", err)
+		}
+		writer.Unlock()
+
+		writer.Destroy()
+		second.Destroy()
+		first.Destroy()
+	})
+}
+
+func TestRWLockRefusesToUnlockTheOtherMode(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		l, err := NewRWLock(g.Session, "/test-rwlock", "holder")
+		if err != nil {
+			t.Fatal("NewRWLock error: ", err)
+		}
+
+		if err := l.RLock(); err != nil {
+			t.Fatal("RLock error: ", err)
+		}
+		if err := l.Unlock(); err != ErrNotHeld {
+			t.Error("Expected ErrNotHeld, but got: ", err)
+		}
+		if err := l.RUnlock(); err != nil {
+			t.Error("RUnlock error: ", err)
+		}
+
+		if err := l.Lock(); err != nil {
+			t.Fatal("Lock error: ", err)
+		}
+		if err := l.RUnlock(); err != ErrNotHeld {
+			t.Error("Expected ErrNotHeld, but got: ", err)
+		}
+		if err := l.Unlock(); err != nil {
+			t.Error("Unlock error: ", err)
+		}
+
+		l.Destroy()
+	})
+}
+
+func TestRWLockLostFiresWhenSessionExpires(t *testing.T) {
+	withTestLock(t, func(g *GlobalLock) {
+		l, err := NewRWLock(g.Session, "/test-rwlock", "holder")
+		if err != nil {
+			t.Fatal("NewRWLock error: ", err)
+		}
+
+		if err := l.RLock(); err != nil {
+			t.Fatal("RLock error: ", err)
+		}
+		if err := l.Session.ForceExpire(); err != nil {
+			t.Fatal("ForceExpire error: ", err)
+		}
+
+		select {
+		case <-l.LockLost():
+		case <-time.After(10 * time.Second):
+			t.Fatal("Expected LockLost to fire after the session expired")
+		}
+
+		if err := l.RUnlock(); err != nil {
+			t.Error("RUnlock error: ", err)
+		}
+		l.Destroy()
+	})
+}
+
+func BenchmarkLockUncontended(b *testing.B) {
+	withTestLock(b, func(g *GlobalLock) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := g.Lock(); err != nil {
+				b.Fatal("Lock error: ", err)
+			}
+			if err := g.Unlock(); err != nil {
+				b.Fatal("Unlock error: ", err)
+			}
+		}
+	})
+}
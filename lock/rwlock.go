@@ -0,0 +1,415 @@
+package lock
+
+/**
+A read-write lock lets any number of readers hold it at once, or a single writer.
+
+Read lock:
+(1) Call Create() with a pathname "{root}/read-" and the zookeeper.EPHEMERAL and zookeeper.SEQUENCE flags set.
+(2) Call Children() on the root.
+(3) If there are no "write-" nodes with a lower sequence number than the node created in step 1, the client has the
+    read lock.
+(4) Else, call Exists() with the watch flag set on the "write-" node with the next lowest sequence number.
+(5) If Exists() returns false, go to step 2. Otherwise, wait for a notification before going to step 2.
+
+Write lock:
+(1) Call Create() with a pathname "{root}/write-" and the zookeeper.EPHEMERAL and zookeeper.SEQUENCE flags set.
+(2) Call Children() on the root.
+(3) If no node has a lower sequence number than the node created in step 1, the client has the write lock.
+(4) Else, call Exists() with the watch flag set on the node, of either kind, with the next lowest sequence number.
+(5) If Exists() returns false, go to step 2. Otherwise, wait for a notification before going to step 2.
+
+The sequence counter is shared by the parent, so the sequence numbers order read and write nodes alike.
+**/
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/gozk"
+	"github.com/Shopify/gozk-recipes/session"
+)
+
+// ErrAlreadyHeld is returned when an RWLock is asked for the read lock while it
+// holds the write lock, or the other way around.
+var ErrAlreadyHeld = errors.New("lock is already held in the other mode")
+
+// ErrNotHeld is returned by RUnlock while an RWLock holds the write lock, and by
+// Unlock while it holds the read lock.
+var ErrNotHeld = errors.New("lock is not held in this mode")
+
+const (
+	readPrefix  = "read-"
+	writePrefix = "write-"
+)
+
+type RWLock struct {
+	Session *session.ZKSession
+	root    string
+	data    string
+
+	mu       sync.Mutex
+	nodePath string
+	held     bool
+	lost     chan struct{}
+	reset    chan struct{}
+
+	watchMu      sync.Mutex
+	stopWatching chan struct{}
+}
+
+// NewRWLock creates root if necessary. The data is stored in our node while we
+// hold or wait for the lock; if it is empty, the session's identity is used.
+// Like a GlobalLock, it only watches the session while it is being acquired or
+// held.
+func NewRWLock(session *session.ZKSession, root string, data string) (*RWLock, error) {
+	if err := session.EnsureRoot(root); err != nil {
+		return nil, err
+	}
+	if data == "" {
+		data = session.Identity()
+	}
+	l := &RWLock{Session: session, root: root, data: data, reset: make(chan struct{})}
+
+	return l, nil
+}
+
+// watch subscribes to the session, unless we already are.
+func (l *RWLock) watch() {
+	l.watchMu.Lock()
+	defer l.watchMu.Unlock()
+
+	if l.stopWatching != nil {
+		return
+	}
+	events := make(chan session.ZKSessionEvent)
+	l.stopWatching = make(chan struct{})
+	l.Session.Subscribe(events)
+	go l.watchSession(events, l.stopWatching)
+}
+
+// unwatch stops watching the session without waiting for the subscription to
+// end.
+func (l *RWLock) unwatch() {
+	l.watchMu.Lock()
+	defer l.watchMu.Unlock()
+
+	if l.stopWatching != nil {
+		close(l.stopWatching)
+		l.stopWatching = nil
+	}
+}
+
+// watchSession forgets our node when the session ends or expires, as ZooKeeper
+// has removed it, and wakes up an acquisition waiting on a watch that will
+// never fire.
+func (l *RWLock) watchSession(events chan session.ZKSessionEvent, stop <-chan struct{}) {
+	for {
+		select {
+		case event := <-events:
+			switch event {
+			case session.SessionExpiredReconnected, session.SessionFailed, session.SessionClosed:
+				l.mu.Lock()
+				l.dropLocked(l.nodePath)
+				close(l.reset)
+				l.reset = make(chan struct{})
+				l.mu.Unlock()
+			}
+		case <-stop:
+			// Unsubscribe waits for an event being delivered to us, so keep
+			// reading until it returns.
+			unsubscribed := make(chan struct{})
+			go func() {
+				l.Session.Unsubscribe(events)
+				close(unsubscribed)
+			}()
+			for {
+				select {
+				case <-events:
+				case <-unsubscribed:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Destroy removes the lock root if no other client is using it, and stops
+// watching the session. The lock must not be used afterwards.
+func (l *RWLock) Destroy() error {
+	l.unwatch()
+
+	children, _, err := l.Session.Children(l.root)
+	if err != nil {
+		return err
+	}
+
+	if len(children) == 0 {
+		return l.Session.Delete(l.root, -1)
+	}
+
+	return nil
+}
+
+// LockLost returns a channel that is closed if the current hold on the lock, in
+// either mode, is lost without it being unlocked, because the session expired or
+// ended or our node was deleted. Each acquisition gets a new channel; the
+// channel is nil if the lock has never been acquired.
+func (l *RWLock) LockLost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// RLock acquires the lock for reading, waiting for any writers queued ahead of
+// us.
+func (l *RWLock) RLock() error {
+	return l.lock(context.Background(), readPrefix)
+}
+
+// RLockContext is like RLock, but gives up once ctx is done, removing our node
+// and returning the context's error.
+func (l *RWLock) RLockContext(ctx context.Context) error {
+	return l.lock(ctx, readPrefix)
+}
+
+// RUnlock releases the read lock.
+func (l *RWLock) RUnlock() error {
+	return l.unlock(readPrefix)
+}
+
+// Lock acquires the lock for writing, waiting for every reader and writer
+// queued ahead of us.
+func (l *RWLock) Lock() error {
+	return l.lock(context.Background(), writePrefix)
+}
+
+// LockContext is like Lock, but gives up once ctx is done, removing our node
+// and returning the context's error.
+func (l *RWLock) LockContext(ctx context.Context) error {
+	return l.lock(ctx, writePrefix)
+}
+
+// Unlock releases the write lock.
+func (l *RWLock) Unlock() error {
+	return l.unlock(writePrefix)
+}
+
+func (l *RWLock) lock(ctx context.Context, prefix string) (err error) {
+	l.watch()
+	defer func() {
+		if err != nil {
+			l.unwatch()
+		}
+	}()
+
+	l.mu.Lock()
+	nodePath, held := l.nodePath, l.held
+	l.mu.Unlock()
+
+	if held {
+		if !strings.HasPrefix(path.Base(nodePath), prefix) {
+			return ErrAlreadyHeld
+		}
+		stat, err := l.Session.Exists(nodePath)
+		if err != nil {
+			return err
+		}
+		if stat != nil {
+			return nil
+		}
+		l.forgetNode(nodePath)
+	} else if len(nodePath) > 0 {
+		// An earlier acquisition was given up, but its node couldn't be
+		// deleted. It may still be queued, so it must go before we start
+		// over.
+		err := l.Session.Delete(nodePath, -1)
+		if err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+			return err
+		}
+		l.forgetNode(nodePath)
+	}
+
+create:
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// (1)
+		nodePath, err := l.Session.Create(l.root+"/"+prefix, l.data, zookeeper.EPHEMERAL|zookeeper.SEQUENCE, l.Session.DefaultACL())
+		if err != nil {
+			return err
+		}
+		l.Session.TrackNode(nodePath)
+
+		l.mu.Lock()
+		l.nodePath = nodePath
+		l.mu.Unlock()
+
+		for {
+			l.mu.Lock()
+			reset := l.reset
+			l.mu.Unlock()
+
+			// (2)
+			children, _, err := l.Session.Children(l.root)
+			if err != nil {
+				l.abandon(nodePath)
+				return err
+			}
+
+			// (3)
+			blocker, ok := blockingNode(path.Base(nodePath), children)
+			if !ok {
+				// Our node is gone, e.g. because the session expired after we
+				// created it.
+				l.forgetNode(nodePath)
+				continue create
+			}
+			if blocker == "" {
+				l.acquired(nodePath)
+				return nil
+			}
+
+			// (4)
+			stat, w, err := l.Session.ExistsW(l.root + "/" + blocker)
+			if err != nil {
+				l.abandon(nodePath)
+				return err
+			}
+			// (5)
+			if stat == nil {
+				continue
+			}
+			select {
+			case <-w:
+			case <-reset:
+			case <-ctx.Done():
+				l.abandon(nodePath)
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (l *RWLock) unlock(prefix string) error {
+	l.mu.Lock()
+	nodePath, held := l.nodePath, l.held
+	l.mu.Unlock()
+
+	if !held {
+		if len(nodePath) > 0 {
+			l.abandon(nodePath)
+		}
+		l.unwatch()
+		return nil
+	}
+	if !strings.HasPrefix(path.Base(nodePath), prefix) {
+		return ErrNotHeld
+	}
+
+	err := l.Session.Delete(nodePath, -1)
+	if err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+		return err
+	}
+
+	l.mu.Lock()
+	if l.nodePath == nodePath {
+		l.held = false
+	}
+	l.dropLocked(nodePath)
+	l.mu.Unlock()
+
+	l.unwatch()
+	return nil
+}
+
+// acquired records that nodePath holds the lock, unless it has been forgotten
+// in the meantime.
+func (l *RWLock) acquired(nodePath string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.nodePath == nodePath {
+		l.held = true
+		l.lost = make(chan struct{})
+	}
+}
+
+// forgetNode drops our record of nodePath, if it is still our node, once it has
+// been deleted or found gone.
+func (l *RWLock) forgetNode(nodePath string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dropLocked(nodePath)
+}
+
+// dropLocked must be called with l.mu held. If nodePath held the lock and
+// wasn't released through unlock, the hold is reported lost.
+func (l *RWLock) dropLocked(nodePath string) {
+	l.Session.UntrackNode(nodePath)
+	if l.nodePath != nodePath {
+		return
+	}
+	l.nodePath = ""
+	if l.held {
+		l.held = false
+		close(l.lost)
+	}
+}
+
+// abandon removes the node of an acquisition that is being given up, so that it
+// doesn't hold up the clients queued behind it.
+func (l *RWLock) abandon(nodePath string) {
+	err := l.Session.Delete(nodePath, -1)
+	if err == nil || zookeeper.IsError(err, zookeeper.ZNONODE) {
+		l.forgetNode(nodePath)
+	}
+}
+
+// bySequence sorts read and write nodes by the sequence number that follows
+// their prefix.
+type bySequence []string
+
+func (s bySequence) Len() int           { return len(s) }
+func (s bySequence) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySequence) Less(i, j int) bool { return sequence(s[i]) < sequence(s[j]) }
+
+func sequence(node string) string {
+	return node[strings.LastIndex(node, "-")+1:]
+}
+
+// blockingNode returns the node that node must wait for, or the empty string if
+// it holds the lock. False is returned if node isn't among the children.
+func blockingNode(node string, children []string) (string, bool) {
+	sort.Sort(bySequence(children))
+
+	index := -1
+	for i, child := range children {
+		if child == node {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return "", false
+	}
+
+	if strings.HasPrefix(node, writePrefix) {
+		if index == 0 {
+			return "", true
+		}
+		return children[index-1], true
+	}
+
+	for i := index - 1; i >= 0; i-- {
+		if strings.HasPrefix(children[i], writePrefix) {
+			return children[i], true
+		}
+	}
+	return "", true
+}
@@ -0,0 +1,79 @@
+package lock
+
+import (
+	"path"
+
+	"github.com/Shopify/gozk"
+)
+
+// TryLock makes a single attempt at acquiring the lock without waiting. If
+// another client holds the lock, our node is removed again and false is
+// returned.
+func (g *GlobalLock) TryLock() (bool, error) {
+	if held, err := g.stillHeld(); held || err != nil {
+		return held, err
+	}
+
+	if err := g.checkHealthy(); err != nil {
+		return false, err
+	}
+
+	g.watch()
+	acquired := false
+	defer func() {
+		if !acquired {
+			g.unwatch()
+		}
+	}()
+
+	err := g.createNode()
+	if zookeeper.IsError(err, zookeeper.ZNONODE) {
+		if err := g.rootDeleted(); err != nil {
+			return false, err
+		}
+		err = g.createNode()
+	}
+	if err != nil {
+		return false, err
+	}
+
+	index, _, err := g.position(path.Base(g.ephemeralPath))
+	if err == ErrNoLockNode {
+		g.forgetNode()
+		return false, nil
+	}
+	if err != nil {
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			g.forgetNode()
+			return false, g.rootDeleted()
+		}
+		g.abandon()
+		return false, err
+	}
+
+	if index != 0 {
+		g.abandon()
+		return false, nil
+	}
+
+	acquired, err = g.claim()
+	return acquired, err
+}
+
+// TryAny attempts each of the locks in turn without waiting, and returns the
+// first one that was acquired, e.g. to pick an idle resource out of a pool.
+// Failed attempts leave no nodes behind. If none of the locks were free, nil is
+// returned, along with the first error encountered, if any.
+func TryAny(locks ...*GlobalLock) (*GlobalLock, error) {
+	var firstErr error
+	for _, g := range locks {
+		acquired, err := g.TryLock()
+		if acquired {
+			return g, nil
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
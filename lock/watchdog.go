@@ -0,0 +1,74 @@
+package lock
+
+import (
+	"time"
+)
+
+// WithWatchdog sets up a diagnostic for leaked locks: once the lock has been
+// held for longer than threshold, a warning including the holder data and the
+// hold duration is logged every interval until it is released. The watchdog
+// never releases the lock itself.
+func WithWatchdog(threshold, interval time.Duration, logger Logger) LockOption {
+	return func(g *GlobalLock) {
+		g.watchdogThreshold = threshold
+		g.watchdogInterval = interval
+		g.watchdogLog = logger
+	}
+}
+
+// Logger is passed to WithWatchdog to log long-held locks. A *log.Logger
+// satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// startWatchdog must be called with g.mu held.
+func (g *GlobalLock) startWatchdog() {
+	if g.watchdogLog == nil || g.watchdogThreshold <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	g.stopWatchdog = stop
+	go g.watchdog(stop, time.Now(), g.ephemeralPath)
+}
+
+// stopWatchdogLocked must be called with g.mu held.
+func (g *GlobalLock) stopWatchdogLocked() {
+	if g.stopWatchdog != nil {
+		close(g.stopWatchdog)
+		g.stopWatchdog = nil
+	}
+}
+
+func (g *GlobalLock) watchdog(stop <-chan struct{}, since time.Time, node string) {
+	threshold := time.NewTimer(g.watchdogThreshold)
+	defer threshold.Stop()
+
+	select {
+	case <-stop:
+		return
+	case <-threshold.C:
+	}
+
+	interval := g.watchdogInterval
+	if interval <= 0 {
+		interval = g.watchdogThreshold
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		g.mu.Lock()
+		data := g.data
+		g.mu.Unlock()
+
+		g.watchdogLog.Printf("gozk-recipes/lock: %s held for %s by %q", node, time.Since(since), data)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package phaser
+
+/**
+A phaser is a reusable barrier: the members of a group rendezvous at the end of
+each phase, and the phaser advances to the next phase once every current member
+has arrived.
+
+The phaser keeps the following nodes under its root:
+
+- {root}/members holds an ephemeral sequential node for each member.
+- {root}/phase holds the current phase number.
+- {root}/arrived-{phase} holds an ephemeral node for each member that has
+  arrived at that phase.
+
+(1) A member arriving at a phase creates its node under {root}/arrived-{phase}.
+(2) It then reads the phase, the members and the arrivals, setting a watch on each.
+(3) If the phase has already advanced, it is done.
+(4) If every current member has arrived, it advances the phase with a version-checked Set. If another member beat it to
+    it, go to step 2.
+(5) Otherwise, wait for any of the watches to fire and go to step 2.
+
+Members that join late take part from the phase they first arrive at. Members that leave, or whose session dies, are
+removed from {root}/members and are no longer waited for.
+**/
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+
+	"github.com/Shopify/gozk"
+	"github.com/Shopify/gozk-recipes/session"
+)
+
+// ErrNotJoined is returned by AwaitAdvance when the phaser has not been joined.
+var ErrNotJoined = errors.New("phaser has not been joined")
+
+// ErrFuturePhase is returned by AwaitAdvance when asked to wait on a phase the
+// phaser hasn't reached yet.
+var ErrFuturePhase = errors.New("phaser has not reached the given phase")
+
+type Phaser struct {
+	Session     *session.ZKSession
+	root        string
+	memberPath  string
+	arrivalPath string
+}
+
+func NewPhaser(session *session.ZKSession, root string) (*Phaser, error) {
+	if err := session.EnsureRoot(root + "/members"); err != nil {
+		return nil, err
+	}
+	_, err := session.Create(root+"/phase", "0", 0, session.DefaultACL())
+	if err != nil && !zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
+		return nil, err
+	}
+	return &Phaser{Session: session, root: root}, nil
+}
+
+// Join registers this phaser as a member of the group. The group will wait for
+// it at every phase until it calls Leave or its session ends. The member's node
+// holds the session's identity.
+func (p *Phaser) Join() (err error) {
+	if len(p.memberPath) > 0 {
+		return nil
+	}
+	p.memberPath, err = p.Session.Create(p.root+"/members/member-", p.Session.Identity(), zookeeper.EPHEMERAL|zookeeper.SEQUENCE, p.Session.DefaultACL())
+	if err == nil {
+		p.Session.TrackNode(p.memberPath)
+	}
+	return err
+}
+
+// Leave removes this phaser from the group, so that the other members no
+// longer wait for it.
+func (p *Phaser) Leave() error {
+	if len(p.memberPath) == 0 {
+		return nil
+	}
+	if err := p.dropArrival(); err != nil {
+		return err
+	}
+	err := p.Session.Delete(p.memberPath, -1)
+	if err == nil || zookeeper.IsError(err, zookeeper.ZNONODE) {
+		p.Session.UntrackNode(p.memberPath)
+		p.memberPath = ""
+		return nil
+	}
+	return err
+}
+
+// Phase returns the current phase number.
+func (p *Phaser) Phase() (int, error) {
+	value, _, err := p.Session.Get(p.root + "/phase")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// AwaitAdvance marks this member as having arrived at phase and blocks until
+// every current member has arrived and the phaser has moved on to the next
+// phase. It returns immediately if the phaser is already past phase.
+func (p *Phaser) AwaitAdvance(phase int) error {
+	if len(p.memberPath) == 0 {
+		return ErrNotJoined
+	}
+
+	current, err := p.Phase()
+	if err != nil {
+		return err
+	}
+	if current > phase {
+		return nil
+	}
+	if current < phase {
+		return ErrFuturePhase
+	}
+
+	// (1)
+	arrivals := fmt.Sprintf("%s/arrived-%d", p.root, phase)
+	arrivalPath := arrivals + "/" + path.Base(p.memberPath)
+	if arrivalPath != p.arrivalPath {
+		if err := p.dropArrival(); err != nil {
+			return err
+		}
+	}
+	if err := p.Session.EnsurePath(arrivals); err != nil {
+		return err
+	}
+	_, err = p.Session.Create(arrivalPath, "", zookeeper.EPHEMERAL, p.Session.DefaultACL())
+	if err != nil && !zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
+		return err
+	}
+	p.Session.TrackNode(arrivalPath)
+	p.arrivalPath = arrivalPath
+
+	for {
+		// (2)
+		value, stat, phaseW, err := p.Session.GetW(p.root + "/phase")
+		if err != nil {
+			return err
+		}
+		current, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+
+		// (3)
+		if current > phase {
+			return nil
+		}
+
+		members, _, membersW, err := p.Session.ChildrenW(p.root + "/members")
+		if err != nil {
+			return err
+		}
+		arrived, _, arrivedW, err := p.Session.ChildrenW(arrivals)
+		if err != nil {
+			if zookeeper.IsError(err, zookeeper.ZNONODE) {
+				// The phase advanced and was cleaned up under us.
+				continue
+			}
+			return err
+		}
+
+		// (4)
+		if allArrived(members, arrived) {
+			_, err := p.Session.Set(p.root+"/phase", strconv.Itoa(phase+1), stat.Version())
+			if err == nil {
+				p.cleanup(phase - 1)
+				return nil
+			}
+			if !zookeeper.IsError(err, zookeeper.ZBADVERSION) {
+				return err
+			}
+			continue
+		}
+
+		// (5)
+		select {
+		case <-phaseW:
+		case <-membersW:
+		case <-arrivedW:
+		}
+	}
+}
+
+// dropArrival deletes our arrival node once we move on to another phase or
+// leave, unless the member that advanced the phaser past it already has.
+func (p *Phaser) dropArrival() error {
+	if len(p.arrivalPath) == 0 {
+		return nil
+	}
+	err := p.Session.Delete(p.arrivalPath, -1)
+	if err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+		return err
+	}
+	p.Session.UntrackNode(p.arrivalPath)
+	p.arrivalPath = ""
+	return nil
+}
+
+// cleanup removes the arrivals of a phase every member has moved on from.
+func (p *Phaser) cleanup(phase int) {
+	if phase < 0 {
+		return
+	}
+	p.Session.DeleteRecursive(fmt.Sprintf("%s/arrived-%d", p.root, phase))
+}
+
+func allArrived(members, arrived []string) bool {
+	seen := make(map[string]bool, len(arrived))
+	for _, node := range arrived {
+		seen[node] = true
+	}
+	for _, member := range members {
+		if !seen[member] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package phaser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/gozk-recipes/session"
+	"github.com/Shopify/gozk-recipes/test"
+)
+
+func withTestPhasers(t *testing.T, n int, f func([]*Phaser)) {
+	phasers := make([]*Phaser, n)
+	for i := range phasers {
+		store, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+		if err != nil {
+			t.Fatal("Failed to connect to Zookeeper: ", err)
+		}
+		defer store.Close()
+
+		if i == 0 {
+			store.DeleteRecursive("/test-phaser")
+		}
+
+		phasers[i], err = NewPhaser(store, "/test-phaser")
+		if err != nil {
+			t.Fatal("NewPhaser error: ", err)
+		}
+		if err := phasers[i].Join(); err != nil {
+			t.Fatal("Join error: ", err)
+		}
+	}
+
+	f(phasers)
+}
+
+func awaitAll(t *testing.T, phasers []*Phaser, phase int) {
+	errs := make(chan error, len(phasers))
+	for _, p := range phasers {
+		go func(p *Phaser) { errs <- p.AwaitAdvance(phase) }(p)
+	}
+
+	for range phasers {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Error("AwaitAdvance error: ", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for phase to advance")
+		}
+	}
+}
+
+func TestAwaitAdvanceAdvancesThroughPhases(t *testing.T) {
+	withTestPhasers(t, 3, func(phasers []*Phaser) {
+		for phase := 0; phase < 3; phase++ {
+			awaitAll(t, phasers, phase)
+
+			current, err := phasers[0].Phase()
+			if err != nil {
+				t.Error("Phase error: ", err)
+			}
+			if current != phase+1 {
+				t.Errorf("Expected phase %d, actual %d", phase+1, current)
+			}
+		}
+	})
+}
+
+func TestAwaitAdvanceDoesNotWaitForMembersThatLeft(t *testing.T) {
+	withTestPhasers(t, 3, func(phasers []*Phaser) {
+		if err := phasers[2].Leave(); err != nil {
+			t.Error("Leave error: ", err)
+		}
+
+		awaitAll(t, phasers[:2], 0)
+	})
+}
+
+func TestAwaitAdvanceWithPastPhaseReturnsImmediately(t *testing.T) {
+	withTestPhasers(t, 1, func(phasers []*Phaser) {
+		awaitAll(t, phasers, 0)
+
+		if err := phasers[0].AwaitAdvance(0); err != nil {
+			t.Error("AwaitAdvance error: ", err)
+		}
+		if err := phasers[0].AwaitAdvance(5); err != ErrFuturePhase {
+			t.Error("Expected ErrFuturePhase, but got: ", err)
+		}
+	})
+}
+
+func TestHeldNodesFollowsMemberAndArrivalNodes(t *testing.T) {
+	withTestPhasers(t, 1, func(phasers []*Phaser) {
+		p := phasers[0]
+
+		if err := p.AwaitAdvance(0); err != nil {
+			t.Fatal("AwaitAdvance error: ", err)
+		}
+		held := p.Session.HeldNodes()
+		if len(held) != 2 {
+			t.Error("Expected the member and arrival nodes to be held, actual: ", held)
+		}
+
+		if err := p.AwaitAdvance(1); err != nil {
+			t.Fatal("AwaitAdvance error: ", err)
+		}
+		held = p.Session.HeldNodes()
+		if len(held) != 2 {
+			t.Error("Expected the earlier arrival node to be dropped, actual: ", held)
+		}
+
+		if err := p.Leave(); err != nil {
+			t.Fatal("Leave error: ", err)
+		}
+		if held := p.Session.HeldNodes(); len(held) != 0 {
+			t.Error("Expected no nodes to be held after leaving, actual: ", held)
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package ring
+
+/**
+A ring assigns each of its current members a slot from 0 to N-1, where N is the number of members, so that work can be
+sharded between them (e.g. by hashing a key modulo N).
+
+(1) Call Create() with a pathname "{root}/member-" and the zookeeper.EPHEMERAL and zookeeper.SEQUENCE flags set.
+(2) Call ChildrenW() on the root. The slot of each member is the rank of its sequence number among the children.
+(3) Wait for the watch to fire and go to step 2.
+
+When a member leaves, every member after it shifts down a slot, so each member must stop working on the shards it no
+longer owns as soon as it learns its new slot.
+**/
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/gozk"
+	"github.com/Shopify/gozk-recipes/session"
+)
+
+// ErrAlreadyJoined is returned by Join when the ring has already been joined.
+var ErrAlreadyJoined = errors.New("ring has already been joined")
+
+// ErrMemberLost is returned by Join when our node disappeared as we joined.
+var ErrMemberLost = errors.New("ring member node was lost")
+
+// retryInterval is how long a member waits before listing the ring again after
+// losing the connection.
+const retryInterval = 100 * time.Millisecond
+
+// Slot is a member's position in the ring.
+type Slot struct {
+	Index int
+	Size  int
+}
+
+type Ring struct {
+	Session *session.ZKSession
+	root    string
+
+	mu         sync.Mutex
+	memberPath string
+	stop       chan struct{}
+}
+
+func NewRing(session *session.ZKSession, root string) (*Ring, error) {
+	if err := session.EnsureRoot(root); err != nil {
+		return nil, err
+	}
+	return &Ring{Session: session, root: root}, nil
+}
+
+// Join adds us to the ring and returns our current slot, along with a channel
+// that receives our new slot whenever membership changes. If the consumer falls
+// behind, only the latest slot is kept. The channel is closed when we Leave, or
+// if our node is lost along with the session or the ring can't be listed any
+// more, in which case the ring must be joined again.
+func (r *Ring) Join() (Slot, <-chan Slot, error) {
+	r.mu.Lock()
+	joined := len(r.memberPath) > 0
+	r.mu.Unlock()
+	if joined {
+		return Slot{}, nil, ErrAlreadyJoined
+	}
+
+	// (1)
+	memberPath, err := r.Session.Create(r.root+"/member-", r.Session.Identity(), zookeeper.EPHEMERAL|zookeeper.SEQUENCE, r.Session.DefaultACL())
+	if err != nil {
+		return Slot{}, nil, err
+	}
+	r.Session.TrackNode(memberPath)
+
+	// (2)
+	children, _, w, err := r.Session.ChildrenW(r.root)
+	if err != nil {
+		r.Session.Delete(memberPath, -1)
+		r.Session.UntrackNode(memberPath)
+		return Slot{}, nil, err
+	}
+
+	slot, ok := slotOf(path.Base(memberPath), children)
+	if !ok {
+		r.Session.UntrackNode(memberPath)
+		return Slot{}, nil, ErrMemberLost
+	}
+
+	stop := make(chan struct{})
+	r.mu.Lock()
+	r.memberPath = memberPath
+	r.stop = stop
+	r.mu.Unlock()
+
+	slots := make(chan Slot, 1)
+	go r.watch(memberPath, slot, w, slots, stop)
+
+	return slot, slots, nil
+}
+
+// Leave removes us from the ring and closes the slot channel.
+func (r *Ring) Leave() error {
+	r.mu.Lock()
+	memberPath, stop := r.memberPath, r.stop
+	r.stop = nil
+	r.mu.Unlock()
+
+	if len(memberPath) == 0 {
+		return nil
+	}
+
+	if stop != nil {
+		close(stop)
+	}
+	err := r.Session.Delete(memberPath, -1)
+	if err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+		return err
+	}
+	r.forget(memberPath)
+	return nil
+}
+
+// forget drops our record of memberPath once it has been deleted, unless we
+// have joined again in the meantime.
+func (r *Ring) forget(memberPath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Session.UntrackNode(memberPath)
+	if r.memberPath == memberPath {
+		r.memberPath = ""
+		r.stop = nil
+	}
+}
+
+// drop gives up our membership after the watch stopped working, so that the
+// ring can be joined again.
+func (r *Ring) drop(memberPath string) {
+	err := r.Session.Delete(memberPath, -1)
+	if err == nil || zookeeper.IsError(err, zookeeper.ZNONODE) {
+		r.forget(memberPath)
+		return
+	}
+	// Leave the node to the end of the session, but let the ring be joined
+	// again.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.memberPath == memberPath {
+		r.memberPath = ""
+		r.stop = nil
+	}
+}
+
+func (r *Ring) watch(memberPath string, last Slot, w <-chan zookeeper.Event, slots chan Slot, stop <-chan struct{}) {
+	defer close(slots)
+
+	node := path.Base(memberPath)
+	for {
+		// (3)
+		select {
+		case <-w:
+		case <-stop:
+			return
+		}
+
+		// (2)
+		children, _, watch, err := r.Session.ChildrenW(r.root)
+		for retryable(err) {
+			select {
+			case <-time.After(retryInterval):
+			case <-stop:
+				return
+			}
+			children, _, watch, err = r.Session.ChildrenW(r.root)
+		}
+		if err != nil {
+			r.drop(memberPath)
+			return
+		}
+		w = watch
+
+		slot, ok := slotOf(node, children)
+		if !ok {
+			r.drop(memberPath)
+			return
+		}
+		if slot == last {
+			continue
+		}
+		last = slot
+
+		// Replace any slot the consumer hasn't picked up yet.
+		select {
+		case <-slots:
+		default:
+		}
+		slots <- slot
+	}
+}
+
+// retryable reports whether err may go away once the session reconnects.
+func retryable(err error) bool {
+	return err == session.ErrZKOperationTimeout ||
+		zookeeper.IsError(err, zookeeper.ZCONNECTIONLOSS) ||
+		zookeeper.IsError(err, zookeeper.ZOPERATIONTIMEOUT)
+}
+
+func slotOf(node string, children []string) (Slot, bool) {
+	sort.Strings(children)
+	index := sort.SearchStrings(children, node)
+	if index == len(children) || children[index] != node {
+		return Slot{}, false
+	}
+	return Slot{Index: index, Size: len(children)}, true
+}
@@ -0,0 +1,103 @@
+package ring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/gozk-recipes/session"
+	"github.com/Shopify/gozk-recipes/test"
+)
+
+func newTestRing(t *testing.T) *Ring {
+	store, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+
+	r, err := NewRing(store, "/test-ring")
+	if err != nil {
+		t.Fatal("NewRing error: ", err)
+	}
+	return r
+}
+
+func assertSlot(t *testing.T, slots <-chan Slot, expected Slot) {
+	select {
+	case slot := <-slots:
+		if slot != expected {
+			t.Errorf("Expected slot %v, actual %v", expected, slot)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Failed to receive slot")
+	}
+}
+
+func TestJoinAssignsSlotsAndShiftsWhenLowerMemberLeaves(t *testing.T) {
+	first := newTestRing(t)
+	defer first.Session.Close()
+	first.Session.DeleteRecursive("/test-ring")
+	first.Session.EnsurePath("/test-ring")
+
+	second := newTestRing(t)
+	defer second.Session.Close()
+
+	slot, firstSlots, err := first.Join()
+	if err != nil {
+		t.Fatal("Join error: ", err)
+	}
+	if slot != (Slot{0, 1}) {
+		t.Error("Expected the first member to have slot 0 of 1, actual: ", slot)
+	}
+
+	slot, secondSlots, err := second.Join()
+	if err != nil {
+		t.Fatal("Join error: ", err)
+	}
+	if slot != (Slot{1, 2}) {
+		t.Error("Expected the second member to have slot 1 of 2, actual: ", slot)
+	}
+	assertSlot(t, firstSlots, Slot{0, 2})
+
+	if err := first.Leave(); err != nil {
+		t.Error("Leave error: ", err)
+	}
+	assertSlot(t, secondSlots, Slot{0, 1})
+
+	if _, ok := <-firstSlots; ok {
+		t.Error("Expected the slot channel to be closed after leaving")
+	}
+}
+
+func TestJoinSucceedsAgainOnceMemberNodeIsLost(t *testing.T) {
+	r := newTestRing(t)
+	defer r.Session.Close()
+	r.Session.DeleteRecursive("/test-ring")
+	r.Session.EnsurePath("/test-ring")
+
+	_, slots, err := r.Join()
+	if err != nil {
+		t.Fatal("Join error: ", err)
+	}
+
+	r.mu.Lock()
+	memberPath := r.memberPath
+	r.mu.Unlock()
+	if err := r.Session.Delete(memberPath, -1); err != nil {
+		t.Fatal("Delete error: ", err)
+	}
+
+	select {
+	case _, ok := <-slots:
+		if ok {
+			t.Error("Expected the slot channel to be closed once our node is lost")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the slot channel to be closed")
+	}
+
+	if _, _, err := r.Join(); err != nil {
+		t.Error("Expected to be able to join again, but got: ", err)
+	}
+
+	r.Leave()
+}
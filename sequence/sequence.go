@@ -0,0 +1,57 @@
+package sequence
+
+/**
+A sequence hands out unique, strictly increasing IDs using the counter ZooKeeper keeps for sequential nodes.
+
+(1) Call Create() with a pathname "{root}/id-" and the zookeeper.EPHEMERAL and zookeeper.SEQUENCE flags set.
+(2) Parse the sequence number ZooKeeper appended to the pathname; this is the ID.
+(3) Delete the node, as only its name was needed.
+
+Unlike a counter this involves no read-modify-write, so concurrent callers never contend on a version check. The IDs
+may have gaps (e.g. when the parent's other children are created), which is fine for IDs. ZooKeeper's counter is a
+signed 32 bit integer, so a root can hand out at most 2147483647 IDs.
+**/
+
+import (
+	"strconv"
+
+	"github.com/Shopify/gozk"
+	"github.com/Shopify/gozk-recipes/session"
+)
+
+// sequenceDigits is the width of the suffix ZooKeeper appends to sequential
+// nodes.
+const sequenceDigits = 10
+
+type Sequence struct {
+	Session *session.ZKSession
+	root    string
+}
+
+func NewSequence(session *session.ZKSession, root string) (*Sequence, error) {
+	if err := session.EnsureRoot(root); err != nil {
+		return nil, err
+	}
+	return &Sequence{session, root}, nil
+}
+
+// Next returns an ID greater than any previously returned for this root.
+func (s *Sequence) Next() (int64, error) {
+	// (1)
+	node, err := s.Session.Create(s.root+"/id-", "", zookeeper.EPHEMERAL|zookeeper.SEQUENCE, s.Session.DefaultACL())
+	if err != nil {
+		return 0, err
+	}
+
+	// (2)
+	id, err := strconv.ParseInt(node[len(node)-sequenceDigits:], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	// (3) The node is ephemeral, so if this fails it is removed along with the
+	// session anyway.
+	s.Session.Delete(node, -1)
+
+	return id, nil
+}
@@ -0,0 +1,103 @@
+package sequence
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Shopify/gozk"
+	"github.com/Shopify/gozk-recipes/session"
+	"github.com/Shopify/gozk-recipes/test"
+)
+
+func newTestStore(t testing.TB) *session.ZKSession {
+	store, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+	store.DeleteRecursive("/test-sequence")
+	return store
+}
+
+func TestNextIsStrictlyIncreasing(t *testing.T) {
+	store := newTestStore(t)
+	defer store.Close()
+
+	seq, err := NewSequence(store, "/test-sequence")
+	if err != nil {
+		t.Fatal("NewSequence error: ", err)
+	}
+
+	last := int64(-1)
+	for i := 0; i < 10; i++ {
+		id, err := seq.Next()
+		if err != nil {
+			t.Fatal("Next error: ", err)
+		}
+		if id <= last {
+			t.Errorf("Expected an ID greater than %d, actual %d", last, id)
+		}
+		last = id
+	}
+
+	children, _, err := store.Children("/test-sequence")
+	if err != nil {
+		t.Error("Children error: ", err)
+	}
+	if len(children) != 0 {
+		t.Error("Expected Next to clean up its nodes, but found: ", children)
+	}
+}
+
+func BenchmarkSequenceNextUnderContention(b *testing.B) {
+	store := newTestStore(b)
+	defer store.Close()
+
+	seq, err := NewSequence(store, "/test-sequence")
+	if err != nil {
+		b.Fatal("NewSequence error: ", err)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := seq.Next(); err != nil {
+				b.Error("Next error: ", err)
+			}
+		}
+	})
+}
+
+// BenchmarkCounterIncrementUnderContention increments a counter node with a
+// version-checked read-modify-write, for comparison with the sequence.
+func BenchmarkCounterIncrementUnderContention(b *testing.B) {
+	store := newTestStore(b)
+	defer store.Close()
+
+	if err := store.CreateRecursiveAndSet("/test-sequence/counter", "0"); err != nil {
+		b.Fatal("CreateRecursiveAndSet error: ", err)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for {
+				value, stat, err := store.Get("/test-sequence/counter")
+				if err != nil {
+					b.Error("Get error: ", err)
+					return
+				}
+				n, _ := strconv.ParseInt(value, 10, 64)
+
+				_, err = store.Set("/test-sequence/counter", strconv.FormatInt(n+1, 10), stat.Version())
+				if err == nil {
+					break
+				}
+				if !zookeeper.IsError(err, zookeeper.ZBADVERSION) {
+					b.Error("Set error: ", err)
+					return
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Shopify/gozk"
+)
+
+// ErrStopChildren can be returned by the function passed to ChildrenStream to
+// stop early without ChildrenStream returning an error.
+var ErrStopChildren = errors.New("stop iterating children")
+
+// maxConcurrentGets bounds the number of Get requests ChildrenWithData will
+// have in flight at once.
+var maxConcurrentGets = 16
+
+// ChildrenWithData returns the data of each of a node's children, keyed by the
+// child's name. The binding has no multi() support, so the children are read
+// with concurrent Gets through a bounded pool of workers. Children that are
+// deleted between listing them and reading them are skipped.
+func (s *ZKSession) ChildrenWithData(path string) (map[string]string, error) {
+	children, _, err := s.Children(path)
+	if err != nil {
+		return nil, err
+	}
+
+	parent := path
+	if parent == "/" {
+		parent = ""
+	}
+
+	workers := maxConcurrentGets
+	if len(children) < workers {
+		workers = len(children)
+	}
+
+	names := make(chan string)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		data     = make(map[string]string, len(children))
+		firstErr error
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				value, _, err := s.Get(parent + "/" + name)
+
+				mu.Lock()
+				switch {
+				case err == nil:
+					data[name] = value
+				case zookeeper.IsError(err, zookeeper.ZNONODE):
+					// The child went away after we listed it.
+				case firstErr == nil:
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, name := range children {
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return data, nil
+}
+
+// ChildrenStream calls fn with the name of each of a node's children, in no
+// particular order, stopping at the first error fn returns. ZooKeeper still
+// sends the whole list, but callers that only need, say, the lowest sequence
+// number can find it without building and sorting a slice of their own.
+func (s *ZKSession) ChildrenStream(path string, fn func(child string) error) error {
+	children, _, err := s.Children(path)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		if err := fn(child); err != nil {
+			if err == ErrStopChildren {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitChildCount blocks until pred holds for the number of children of the
+// node at path, re-arming a child watch each time it doesn't, or until ctx is
+// done, in which case ctx.Err() is returned. Recipes pass predicates like
+// func(n int) bool { return n >= count }.
+func (s *ZKSession) WaitChildCount(ctx context.Context, path string, pred func(n int) bool) error {
+	for {
+		children, _, w, err := s.ChildrenW(path)
+		if err != nil {
+			return err
+		}
+		if pred(len(children)) {
+			return nil
+		}
+
+		select {
+		case <-w:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChildrenWithDataShouldReturnEachChildsData(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		initializeZK(t, session, "/test", "/test/foo", "/test/bar")
+
+		if _, err := session.Set("/test/foo", "spam", -1); err != nil {
+			t.Error("Set error: ", err)
+		}
+		if _, err := session.Set("/test/bar", "eggs", -1); err != nil {
+			t.Error("Set error: ", err)
+		}
+
+		data, err := session.ChildrenWithData("/test")
+		if err != nil {
+			t.Error("ChildrenWithData error: ", err)
+		}
+
+		AssertEqual(t, map[string]string{"foo": "spam", "bar": "eggs"}, data)
+	})
+}
+
+func TestChildrenWithDataWithNoChildrenShouldHaveEmptyResult(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		initializeZK(t, session, "/test")
+
+		data, err := session.ChildrenWithData("/test")
+		if err != nil {
+			t.Error("ChildrenWithData error: ", err)
+		}
+
+		AssertEqual(t, map[string]string{}, data)
+	})
+}
+
+func TestChildrenStreamShouldStopEarly(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		initializeZK(t, session, "/test", "/test/foo", "/test/bar", "/test/eggs")
+
+		seen := 0
+		err := session.ChildrenStream("/test", func(child string) error {
+			seen++
+			if seen == 2 {
+				return ErrStopChildren
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error("ChildrenStream error: ", err)
+		}
+
+		AssertEqual(t, 2, seen)
+	})
+}
+
+func TestWaitChildCountShouldReturnOncePredicateHolds(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		initializeZK(t, session, "/test", "/test/foo")
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			initializeZK(t, session, "/test/bar")
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := session.WaitChildCount(ctx, "/test", func(n int) bool { return n >= 2 })
+		if err != nil {
+			t.Error("WaitChildCount error: ", err)
+		}
+	})
+}
+
+func TestWaitChildCountShouldStopWhenContextIsDone(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		initializeZK(t, session, "/test")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		err := session.WaitChildCount(ctx, "/test", func(n int) bool { return n > 0 })
+		if err != context.DeadlineExceeded {
+			t.Error("Expected context.DeadlineExceeded, but got: ", err)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/gozk"
+)
+
+// ErrEnsembleConfigUnsupported is returned by EnsembleConfig when the ensemble
+// doesn't publish its configuration, as servers older than ZooKeeper 3.5 don't.
+var ErrEnsembleConfigUnsupported = errors.New("ensemble does not publish its configuration")
+
+// ensembleConfigPath is where servers supporting dynamic reconfiguration
+// publish the ensemble's configuration.
+const ensembleConfigPath = "/zookeeper/config"
+
+// EnsembleConfig returns the client address ("host:port") of each server in
+// the ensemble, as currently configured, so that clients can update their
+// connection string after the ensemble is reconfigured.
+func (s *ZKSession) EnsembleConfig() ([]string, error) {
+	config, _, err := s.Get(ensembleConfigPath)
+	if zookeeper.IsError(err, zookeeper.ZNONODE) {
+		return nil, ErrEnsembleConfigUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	servers := parseEnsembleConfig(config)
+	if len(servers) == 0 {
+		return nil, ErrEnsembleConfigUnsupported
+	}
+	return servers, nil
+}
+
+// parseEnsembleConfig extracts the client addresses from lines of the form
+//
+//	server.1=zk1:2888:3888:participant;0.0.0.0:2181
+//
+// where the client address after the semicolon may be just a port, or may
+// listen on every interface, in which case the server's own host is used.
+func parseEnsembleConfig(config string) []string {
+	var servers []string
+	for _, line := range strings.Split(config, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "server.") {
+			continue
+		}
+
+		equals := strings.Index(line, "=")
+		semicolon := strings.LastIndex(line, ";")
+		if equals < 0 || semicolon < equals {
+			continue
+		}
+		serverHost := strings.SplitN(line[equals+1:semicolon], ":", 2)[0]
+
+		clientHost, clientPort, err := net.SplitHostPort(line[semicolon+1:])
+		if err != nil {
+			// Only a port was given.
+			clientHost, clientPort = "", line[semicolon+1:]
+		}
+		if clientHost == "" || clientHost == "0.0.0.0" || clientHost == "::" {
+			clientHost = serverHost
+		}
+		servers = append(servers, net.JoinHostPort(clientHost, clientPort))
+	}
+	sort.Strings(servers)
+	return servers
+}
@@ -0,0 +1,33 @@
+package session
+
+import (
+	"testing"
+)
+
+func TestParseEnsembleConfigShouldReturnClientAddresses(t *testing.T) {
+	config := "server.1=zk1:2888:3888:participant;0.0.0.0:2181\n" +
+		"server.2=zk2:2888:3888:participant;10.0.0.2:2182\n" +
+		"server.3=zk3:2888:3888:observer;2183\n" +
+		"version=100000000"
+
+	AssertEqual(t, []string{"10.0.0.2:2182", "zk1:2181", "zk3:2183"}, parseEnsembleConfig(config))
+}
+
+func TestParseEnsembleConfigWithoutClientAddressesShouldBeEmpty(t *testing.T) {
+	AssertEqual(t, 0, len(parseEnsembleConfig("server.1=zk1:2888:3888\n")))
+}
+
+func TestEnsembleConfigShouldReturnServersOrUnsupported(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		servers, err := session.EnsembleConfig()
+		if err == ErrEnsembleConfigUnsupported {
+			return
+		}
+		if err != nil {
+			t.Fatal("EnsembleConfig error: ", err)
+		}
+		if len(servers) == 0 {
+			t.Error("Expected at least one server")
+		}
+	})
+}
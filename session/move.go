@@ -0,0 +1,37 @@
+package session
+
+import (
+	"github.com/Shopify/gozk"
+)
+
+// Move copies the data of the node at src to a new node at dst and deletes
+// src, returning the path of the created node. Flags are passed to Create, so
+// that dst can be a sequential node. The binding has no multi() support, so
+// the copy and the delete are separate operations: if src is changed or
+// deleted after it was read, e.g. by a concurrent Move, the copy is removed
+// again and the error of the delete is returned, leaving exactly one node.
+// Only leaf nodes can be moved.
+func (s *ZKSession) Move(src, dst string, flags int) (string, error) {
+	data, stat, err := s.Get(src)
+	if err != nil {
+		return "", err
+	}
+
+	acl, _, err := s.ACL(src)
+	if err != nil {
+		return "", err
+	}
+
+	created, err := s.Create(dst, data, flags, acl)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.Delete(src, stat.Version()); err != nil {
+		if zookeeper.IsError(err, zookeeper.ZBADVERSION) || zookeeper.IsError(err, zookeeper.ZNONODE) {
+			s.Delete(created, -1)
+		}
+		return "", err
+	}
+	return created, nil
+}
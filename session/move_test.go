@@ -0,0 +1,41 @@
+package session
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Shopify/gozk"
+)
+
+func TestMoveShouldCopyDataAndDeleteSource(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		initializeZK(t, session, "/test", "/test/from", "/test/to", "/test/from/item")
+
+		if _, err := session.Set("/test/from/item", "spam", -1); err != nil {
+			t.Error("Set error: ", err)
+		}
+
+		created, err := session.Move("/test/from/item", "/test/to/item-", zookeeper.SEQUENCE)
+		if err != nil {
+			t.Error("Move error: ", err)
+		}
+
+		if !strings.HasPrefix(created, "/test/to/item-") || created == "/test/to/item-" {
+			t.Error("Expected a sequential node, but got: ", created)
+		}
+		AssertNodeValueEqual(t, session, created, "spam")
+		AssertNodeDoesNotExist(t, session, "/test/from/item")
+	})
+}
+
+func TestMoveWithMissingSourceShouldFail(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		initializeZK(t, session, "/test")
+
+		_, err := session.Move("/test/missing", "/test/item", 0)
+		if !zookeeper.IsError(err, zookeeper.ZNONODE) {
+			t.Error("Expected ZNONODE, but got: ", err)
+		}
+		AssertNodeDoesNotExist(t, session, "/test/item")
+	})
+}
@@ -1,6 +1,7 @@
 package session
 
 import (
+	"errors"
 	"sort"
 	"strings"
 
@@ -9,6 +10,9 @@ import (
 
 var defaultACLs = zookeeper.WorldACL(zookeeper.PERM_ALL)
 
+// ErrInvalidPath is returned for a path that doesn't start with "/".
+var ErrInvalidPath = errors.New("path must be absolute")
+
 // ChildrenRecursive returns a slice all of a node's descendents that are at
 // most `maxDepth` levels away from the root.
 func (s *ZKSession) ChildrenRecursive(path string, maxDepth int) ([]string, error) {
@@ -47,34 +51,141 @@ func (s *ZKSession) ChildrenRecursive(path string, maxDepth int) ([]string, erro
 // CreateRecursiveAndSet will set data for the given path, creating all parents
 // as necessary.
 func (s *ZKSession) CreateRecursiveAndSet(path string, data string) error {
-	// Since the Set method requires us to create intermediate nodes, we have to
-	// do a little extra work here
-	index := 0
-	for {
-		distanceToNextSlash := strings.Index(path[index+1:], "/")
-		if distanceToNextSlash < 0 {
-			break
+	if !strings.HasPrefix(path, "/") {
+		return ErrInvalidPath
+	}
+
+	if parent := path[:strings.LastIndex(path, "/")]; parent != "" {
+		if err := s.EnsurePath(parent); err != nil {
+			return err
 		}
+	}
 
-		index += distanceToNextSlash + 1
-		stat, err := s.Exists(path[:index])
+	return s.Upsert(path, data, nil)
+}
+
+// maxEnsuredPaths bounds the number of paths EnsurePath remembers.
+var maxEnsuredPaths = 1024
+
+// EnsurePath creates the node at path, and all of its parents, if they don't
+// already exist. Paths that have been ensured are remembered, so that hot paths
+// can call EnsurePath without a round trip each time. Deleting a path through
+// this session forgets it, but deletions by other clients aren't noticed, so
+// the cache is also reset whenever the session reconnects, and every level is
+// checked again if a remembered parent turns out to be gone.
+func (s *ZKSession) EnsurePath(path string) error {
+	err := s.ensurePath(path)
+	if zookeeper.IsError(err, zookeeper.ZNONODE) {
+		for i := 1; i <= len(path); i++ {
+			if i == len(path) || path[i] == '/' {
+				s.forgetEnsuredPrefix(path[:i])
+			}
+		}
+		err = s.ensurePath(path)
+	}
+	return err
+}
+
+// EnsureRoot is EnsurePath for the root of a recipe: the node at path is always
+// checked with a round trip, as another client may have deleted it, e.g.
+// through GlobalLock.Destroy, since this session last ensured it.
+func (s *ZKSession) EnsureRoot(path string) error {
+	s.forgetEnsuredPath(path)
+	return s.EnsurePath(path)
+}
+
+func (s *ZKSession) ensurePath(path string) error {
+	for i := 1; i <= len(path); i++ {
+		if i < len(path) && path[i] != '/' {
+			continue
+		}
+
+		prefix := path[:i]
+		if s.pathEnsured(prefix) {
+			continue
+		}
+
+		stat, err := s.Exists(prefix)
 		if err != nil {
 			return err
 		}
 
 		if stat == nil {
-			if _, err := s.Create(path[:index], "", 0, defaultACLs); err != nil {
+			_, err := s.Create(prefix, "", 0, s.DefaultACL())
+			if err != nil && !zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
 				return err
 			}
 		}
+		s.rememberEnsuredPath(prefix)
 	}
+	return nil
+}
 
-	stat, err := s.Set(path, data, -1)
-	if stat == nil {
-		_, err = s.Create(path, data, 0, defaultACLs)
+// ForgetPath drops path, and everything below it, from the cache kept by
+// EnsurePath, e.g. after finding that another client has deleted it.
+func (s *ZKSession) ForgetPath(path string) {
+	s.forgetEnsuredPath(path)
+}
+
+func (s *ZKSession) pathEnsured(path string) bool {
+	s.ensuredMu.Lock()
+	defer s.ensuredMu.Unlock()
+	_, ok := s.ensured[path]
+	return ok
+}
+
+func (s *ZKSession) rememberEnsuredPath(path string) {
+	s.ensuredMu.Lock()
+	defer s.ensuredMu.Unlock()
+	if len(s.ensured) >= maxEnsuredPaths {
+		s.ensured = make(map[string]struct{})
 	}
+	s.ensured[path] = struct{}{}
+}
 
-	return err
+func (s *ZKSession) forgetEnsuredPath(path string) {
+	s.ensuredMu.Lock()
+	defer s.ensuredMu.Unlock()
+	for ensured := range s.ensured {
+		if ensured == path || strings.HasPrefix(ensured, path+"/") {
+			delete(s.ensured, ensured)
+		}
+	}
+}
+
+// forgetEnsuredPrefix forgets path alone, leaving the paths below it cached.
+func (s *ZKSession) forgetEnsuredPrefix(path string) {
+	s.ensuredMu.Lock()
+	defer s.ensuredMu.Unlock()
+	delete(s.ensured, path)
+}
+
+func (s *ZKSession) resetEnsuredPaths() {
+	s.ensuredMu.Lock()
+	defer s.ensuredMu.Unlock()
+	s.ensured = make(map[string]struct{})
+}
+
+// Upsert creates the node at path with the given data, or sets its data if it
+// already exists. The parent must exist. A nil acl means the
+// session's DefaultACL.
+func (s *ZKSession) Upsert(path string, data string, acl []zookeeper.ACL) error {
+	if acl == nil {
+		acl = s.DefaultACL()
+	}
+
+	for {
+		_, err := s.Create(path, data, 0, acl)
+		if !zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
+			return err
+		}
+
+		_, err = s.Set(path, data, -1)
+		if !zookeeper.IsError(err, zookeeper.ZNONODE) {
+			return err
+		}
+		// The node was deleted between the Create and the Set; try again.
+	}
 }
 
 type nodePaths []string
@@ -148,6 +148,11 @@ func TestCreateRecursiveAndSetWithParentsShouldNotChangeData(t *testing.T) {
 	})
 }
 
+func TestCreateRecursiveAndSetWithRelativePathShouldFail(t *testing.T) {
+	session := &ZKSession{}
+	AssertEqual(t, ErrInvalidPath, session.CreateRecursiveAndSet("foo", "foobar"))
+}
+
 func TestDeleteRecursiveShouldDelete(t *testing.T) {
 	withTestStore(t, func(session *ZKSession) {
 		initializeZK(t, session, "/test", "/test/foo", "/test/foo/bar", "/test/foo/bar/spam")
@@ -162,3 +167,74 @@ func TestDeleteRecursiveShouldDelete(t *testing.T) {
 		AssertNodeExists(t, session, "/test")
 	})
 }
+
+func TestUpsertWithNoNodeShouldCreateNode(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		initializeZK(t, session, "/test")
+
+		if err := session.Upsert("/test/foo", "spam", nil); err != nil {
+			t.Error("Upsert error: ", err)
+		}
+
+		AssertNodeValueEqual(t, session, "/test/foo", "spam")
+	})
+}
+
+func TestUpsertWithExistingNodeShouldSetData(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		initializeZK(t, session, "/test", "/test/foo")
+
+		if err := session.Upsert("/test/foo", "eggs", nil); err != nil {
+			t.Error("Upsert error: ", err)
+		}
+
+		AssertNodeValueEqual(t, session, "/test/foo", "eggs")
+	})
+}
+
+func TestEnsurePathShouldCreateMissingNodes(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		initializeZK(t, session, "/test")
+
+		if err := session.EnsurePath("/test/foo/bar"); err != nil {
+			t.Error("EnsurePath error: ", err)
+		}
+
+		AssertNodeExists(t, session, "/test/foo")
+		AssertNodeExists(t, session, "/test/foo/bar")
+	})
+}
+
+func TestEnsurePathAfterDeleteShouldRecreateNodes(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		if err := session.EnsurePath("/test/foo"); err != nil {
+			t.Error("EnsurePath error: ", err)
+		}
+
+		if err := session.DeleteRecursive("/test"); err != nil {
+			t.Error("DeleteRecursive error: ", err)
+		}
+
+		if err := session.EnsurePath("/test/foo"); err != nil {
+			t.Error("EnsurePath error: ", err)
+		}
+
+		AssertNodeExists(t, session, "/test/foo")
+	})
+}
+
+func TestEnsurePathAfterParentDeletedByAnotherClientShouldRecreateNodes(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		if err := session.EnsurePath("/test/foo/bar"); err != nil {
+			t.Fatal("EnsurePath error: ", err)
+		}
+
+		// Another session deletes /test as it starts up.
+		withTestStore(t, func(other *ZKSession) {})
+
+		if err := session.EnsurePath("/test/foo/bar/baz"); err != nil {
+			t.Error("EnsurePath error: ", err)
+		}
+		AssertNodeExists(t, session, "/test/foo/bar/baz")
+	})
+}
@@ -2,7 +2,11 @@ package session
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/gozk"
@@ -27,6 +31,10 @@ var ErrZKSessionNotConnected = errors.New("unable to connect to ZooKeeper")
 // become disconnected in a way deemed unrecoverable.
 var ErrZKSessionDisconnected = errors.New("connection to ZooKeeper was lost")
 
+// ErrZKOperationTimeout is returned by an operation that didn't complete within
+// the session's operation timeout.
+var ErrZKOperationTimeout = errors.New("ZooKeeper operation timed out")
+
 const (
 	// SessionClosed is normally only returned as a direct result of calling Close() on the ZKSession object. It is a
 	// terminal state; the connection will not be re-established.
@@ -47,27 +55,137 @@ const (
 	SessionFailed
 )
 
+// defaultConnectTimeout is how long NewZKSession waits for the initial
+// connection unless WithConnectTimeout is given.
+const defaultConnectTimeout = 5 * time.Second
+
+// defaultOperationTimeout is how long an individual operation may take unless
+// WithOperationTimeout is given.
+const defaultOperationTimeout = 30 * time.Second
+
 type ZKSession struct {
-	servers     string
-	recvTimeout time.Duration
-	conn        *zookeeper.Conn
-	clientID    *zookeeper.ClientId
-	events      <-chan zookeeper.Event
-	mu          sync.Mutex
+	servers        string
+	recvTimeout    time.Duration
+	connectTimeout time.Duration
+	opTimeout      time.Duration
+	identity       string
+	acl            []zookeeper.ACL
+	conn           *zookeeper.Conn
+	clientID       *zookeeper.ClientId
+	events         <-chan zookeeper.Event
+	mu             sync.Mutex
+
+	subscriptions  []*subscription
+	subBufferSize  int
+	overflowPolicy OverflowPolicy
+	droppedEvents  uint64
+	log            stdLogger
+
+	heldMu sync.Mutex
+	held   map[string]struct{}
+
+	ensuredMu sync.Mutex
+	ensured   map[string]struct{}
+
+	healthMu       sync.Mutex
+	connected      bool
+	connectedSince time.Time
+}
+
+// SessionOption configures optional behaviour of a ZKSession.
+type SessionOption func(*ZKSession)
+
+// WithConnectTimeout sets how long to wait for the initial connection to be
+// established before giving up with ErrZKSessionNotConnected. Defaults to five
+// seconds.
+func WithConnectTimeout(timeout time.Duration) SessionOption {
+	return func(s *ZKSession) {
+		s.connectTimeout = timeout
+	}
+}
+
+// WithOperationTimeout sets how long Create, Children, Exists, Get, Set,
+// Delete, GetACL, SetACL and their watching variants may block before failing
+// with ErrZKOperationTimeout. Defaults to thirty seconds; zero disables the
+// timeout.
+//
+// The request isn't cancelled on the server, so a write that timed out may
+// still be applied. Ephemeral nodes whose Create timed out are deleted again
+// once the create completes; other writes are left as they are.
+func WithOperationTimeout(timeout time.Duration) SessionOption {
+	return func(s *ZKSession) {
+		s.opTimeout = timeout
+	}
+}
+
+// WithSubscriptionBuffer buffers up to size events for each subscriber, so that
+// a subscriber that is momentarily busy doesn't hold up delivery to the others.
+// The policy decides what happens once a subscriber's buffer is full. Without
+// this option subscribers are unbuffered and delivery blocks on each in turn.
+func WithSubscriptionBuffer(size int, policy OverflowPolicy) SessionOption {
+	return func(s *ZKSession) {
+		s.subBufferSize = size
+		s.overflowPolicy = policy
+	}
+}
 
-	subscriptions []chan<- ZKSessionEvent
-	log           stdLogger
+// WithIdentity sets the data recipes store in the ephemeral nodes they create
+// through this session when they aren't given any data explicitly, so that
+// lock holders, phaser members and so on can be traced back to this process.
+// See HostIdentity for a suitable value.
+func WithIdentity(identity string) SessionOption {
+	return func(s *ZKSession) {
+		s.identity = identity
+	}
 }
 
-func ResumeZKSession(servers string, recvTimeout time.Duration, logger stdLogger, clientId *zookeeper.ClientId) (*ZKSession, error) {
-	return newZKSession(servers, recvTimeout, logger, clientId)
+// WithDefaultACL sets the ACL that recipes, EnsurePath and Upsert apply to the
+// nodes they create through this session, e.g. a digest ACL to go with
+// AddAuth. An ACL passed explicitly to a call takes precedence over the
+// session's default, which in turn replaces the fallback of
+// zookeeper.WorldACL(zookeeper.PERM_ALL).
+func WithDefaultACL(acl []zookeeper.ACL) SessionOption {
+	return func(s *ZKSession) {
+		s.acl = acl
+	}
+}
+
+// HostIdentity returns an identity of the form "host/pid/app" for WithIdentity.
+func HostIdentity(app string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), app)
 }
 
-func NewZKSession(servers string, recvTimeout time.Duration, logger stdLogger) (*ZKSession, error) {
-	return newZKSession(servers, recvTimeout, logger, nil)
+func ResumeZKSession(servers string, recvTimeout time.Duration, logger stdLogger, clientId *zookeeper.ClientId, opts ...SessionOption) (*ZKSession, error) {
+	return newZKSession(servers, recvTimeout, logger, clientId, opts)
+}
+
+func NewZKSession(servers string, recvTimeout time.Duration, logger stdLogger, opts ...SessionOption) (*ZKSession, error) {
+	return newZKSession(servers, recvTimeout, logger, nil, opts)
+}
+
+// ResumeOrNewZKSession attempts to resume the session saved by SaveClientId,
+// e.g. by a previous run of the process, so that its ephemeral nodes are kept.
+// If there is no saved session, or it can't be resumed because the server has
+// already expired it, a fresh session is established instead. The returned
+// bool reports whether the saved session was resumed.
+func ResumeOrNewZKSession(servers string, recvTimeout time.Duration, logger stdLogger, savedClientId []byte, opts ...SessionOption) (*ZKSession, bool, error) {
+	if len(savedClientId) > 0 {
+		if clientId, err := zookeeper.LoadClientId(savedClientId); err == nil {
+			if s, err := ResumeZKSession(servers, recvTimeout, logger, clientId, opts...); err == nil {
+				return s, true, nil
+			}
+		}
+	}
+
+	s, err := NewZKSession(servers, recvTimeout, logger, opts...)
+	return s, false, err
 }
 
-func newZKSession(servers string, recvTimeout time.Duration, logger stdLogger, clientId *zookeeper.ClientId) (*ZKSession, error) {
+func newZKSession(servers string, recvTimeout time.Duration, logger stdLogger, clientId *zookeeper.ClientId, opts []SessionOption) (*ZKSession, error) {
 	var conn *zookeeper.Conn
 	var events <-chan zookeeper.Event
 	var err error
@@ -86,26 +204,36 @@ func newZKSession(servers string, recvTimeout time.Duration, logger stdLogger, c
 	}
 
 	s := &ZKSession{
-		servers:       servers,
-		recvTimeout:   recvTimeout,
-		conn:          conn,
-		clientID:      conn.ClientId(),
-		events:        events,
-		subscriptions: make([]chan<- ZKSessionEvent, 0),
-		log:           logger,
+		servers:        servers,
+		recvTimeout:    recvTimeout,
+		connectTimeout: defaultConnectTimeout,
+		opTimeout:      defaultOperationTimeout,
+		conn:           conn,
+		clientID:       conn.ClientId(),
+		events:         events,
+		subscriptions:  make([]*subscription, 0),
+		log:            logger,
+		held:           make(map[string]struct{}),
+		ensured:        make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 
-	err = waitForConnection(events)
+	err = waitForConnection(events, s.connectTimeout)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	s.setConnected(true)
 
 	go s.manage()
 
 	return s, nil
 }
 
-func waitForConnection(events <-chan zookeeper.Event) error {
+func waitForConnection(events <-chan zookeeper.Event, timeout time.Duration) error {
+	deadline := time.After(timeout)
 	for {
 		select {
 		case event := <-events:
@@ -115,7 +243,7 @@ func waitForConnection(events <-chan zookeeper.Event) error {
 			case zookeeper.STATE_CONNECTED:
 				return nil
 			}
-		case <-time.After(5 * time.Second):
+		case <-deadline:
 			return ErrZKSessionNotConnected
 		}
 	}
@@ -123,18 +251,108 @@ func waitForConnection(events <-chan zookeeper.Event) error {
 	return ErrZKSessionNotConnected
 }
 
-func (s *ZKSession) Subscribe(subscription chan<- ZKSessionEvent) {
+func (s *ZKSession) Subscribe(subscriber chan<- ZKSessionEvent) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.subscriptions = append(s.subscriptions, subscription)
+	s.subscriptions = append(s.subscriptions, newSubscription(subscriber, s.subBufferSize))
+}
+
+// Unsubscribe stops the delivery of events to a channel given to Subscribe. The
+// channel must keep being read until Unsubscribe returns; no events are sent to
+// it afterwards, and any still buffered for it are discarded.
+func (s *ZKSession) Unsubscribe(subscriber chan<- ZKSessionEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, subscription := range s.subscriptions {
+		if subscription.out == subscriber {
+			subscription.stop()
+			s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+			return
+		}
+	}
 }
 
 func (s *ZKSession) notifySubscribers(event ZKSessionEvent) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for _, subscriber := range s.subscriptions {
-		subscriber <- event
+	for _, subscription := range s.subscriptions {
+		if !subscription.deliver(event, s.overflowPolicy) {
+			atomic.AddUint64(&s.droppedEvents, 1)
+		}
+	}
+}
+
+// DroppedEvents returns how many events have been discarded for subscribers
+// whose buffer was full under DropOnOverflow.
+func (s *ZKSession) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&s.droppedEvents)
+}
+
+// Identity returns the identity given with WithIdentity, if any.
+func (s *ZKSession) Identity() string {
+	return s.identity
+}
+
+// TrackNode records that a recipe has created the ephemeral node at path on
+// behalf of this session. Recipes call UntrackNode when they delete it.
+func (s *ZKSession) TrackNode(path string) {
+	s.heldMu.Lock()
+	defer s.heldMu.Unlock()
+	s.held[path] = struct{}{}
+}
+
+// UntrackNode reverses TrackNode.
+func (s *ZKSession) UntrackNode(path string) {
+	s.heldMu.Lock()
+	defer s.heldMu.Unlock()
+	delete(s.held, path)
+}
+
+// HeldNodes returns the paths of the ephemeral nodes recipes currently hold
+// through this session, sorted. The list is cleared when the session expires,
+// since ZooKeeper purges the nodes.
+func (s *ZKSession) HeldNodes() []string {
+	s.heldMu.Lock()
+	defer s.heldMu.Unlock()
+
+	nodes := make([]string, 0, len(s.held))
+	for node := range s.held {
+		nodes = append(nodes, node)
 	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func (s *ZKSession) clearHeldNodes() {
+	s.heldMu.Lock()
+	defer s.heldMu.Unlock()
+	s.held = make(map[string]struct{})
+}
+
+// DefaultACL returns the ACL given by WithDefaultACL, or
+// zookeeper.WorldACL(zookeeper.PERM_ALL) if there is none.
+func (s *ZKSession) DefaultACL() []zookeeper.ACL {
+	if s.acl == nil {
+		return defaultACLs
+	}
+	return s.acl
+}
+
+// ConnectedSince returns when the session last (re)connected, and false if it
+// isn't currently connected.
+func (s *ZKSession) ConnectedSince() (time.Time, bool) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	return s.connectedSince, s.connected
+}
+
+func (s *ZKSession) setConnected(connected bool) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	if connected && !s.connected {
+		s.connectedSince = time.Now()
+	}
+	s.connected = connected
 }
 
 func (s *ZKSession) manage() {
@@ -145,7 +363,11 @@ func (s *ZKSession) manage() {
 			switch event.State {
 			case zookeeper.STATE_EXPIRED_SESSION:
 				expired = true
-				conn, events, err := zookeeper.Redial(s.servers, s.recvTimeout, s.clientID)
+				s.setConnected(false)
+				s.clearHeldNodes()
+				// The server won't take the expired session back, so dial a new
+				// one rather than redialing s.clientID.
+				conn, events, err := zookeeper.Dial(s.servers, s.recvTimeout)
 				if err == nil {
 					s.mu.Lock()
 					if s.conn != nil {
@@ -166,11 +388,13 @@ func (s *ZKSession) manage() {
 				}
 
 			case zookeeper.STATE_AUTH_FAILED:
+				s.setConnected(false)
 				s.notifySubscribers(SessionFailed)
 				s.log.Printf("gozk-recipes/session.SessionFailed: zookeeper.STATE_AUTH_FAILURE, session terminated")
 				return
 
 			case zookeeper.STATE_CONNECTING:
+				s.setConnected(false)
 				s.notifySubscribers(SessionDisconnected)
 				s.log.Printf("gozk-recipes/session.SessionDisconnected: attempting to reconnect")
 
@@ -178,6 +402,8 @@ func (s *ZKSession) manage() {
 				// No action to take, this is fine.
 
 			case zookeeper.STATE_CONNECTED:
+				s.setConnected(true)
+				s.resetEnsuredPaths()
 				if expired {
 					s.notifySubscribers(SessionExpiredReconnected)
 					s.log.Printf("gozk-recipes/session.SessionExpiredReconnected: all ephemeral nodes purged")
@@ -187,6 +413,7 @@ func (s *ZKSession) manage() {
 					s.log.Printf("gozk-recipes/session.SessionReconnected: reconnected before timed out")
 				}
 			case zookeeper.STATE_CLOSED:
+				s.setConnected(false)
 				s.notifySubscribers(SessionClosed)
 				s.log.Printf("gozk-recipes/session.SessionClosed: normally caused by call to Close(), session terminated")
 				return
@@ -196,7 +423,7 @@ func (s *ZKSession) manage() {
 }
 
 func (s *ZKSession) ACL(path string) ([]zookeeper.ACL, *zookeeper.Stat, error) {
-	return s.conn.ACL(path)
+	return s.GetACL(path)
 }
 
 func (s *ZKSession) AddAuth(scheme, cert string) error {
@@ -204,53 +431,213 @@ func (s *ZKSession) AddAuth(scheme, cert string) error {
 }
 
 func (s *ZKSession) Children(path string) ([]string, *zookeeper.Stat, error) {
-	return s.conn.Children(path)
+	var children []string
+	var stat *zookeeper.Stat
+	var err error
+	if timeoutErr := s.withTimeout(func() { children, stat, err = s.conn.Children(path) }); timeoutErr != nil {
+		return nil, nil, timeoutErr
+	}
+	return children, stat, err
 }
 
 func (s *ZKSession) ChildrenW(path string) ([]string, *zookeeper.Stat, <-chan zookeeper.Event, error) {
-	return s.conn.ChildrenW(path)
+	var children []string
+	var stat *zookeeper.Stat
+	var watch <-chan zookeeper.Event
+	var err error
+	if timeoutErr := s.withTimeout(func() { children, stat, watch, err = s.conn.ChildrenW(path) }); timeoutErr != nil {
+		return nil, nil, nil, timeoutErr
+	}
+	return children, stat, watch, err
+}
+
+// Raw returns the underlying connection, as an escape hatch for features the
+// session doesn't expose. Calls made through it bypass the session entirely:
+// they aren't subject to WithOperationTimeout, don't update the cache kept by
+// EnsurePath, and ephemeral nodes created through it aren't tracked in
+// HeldNodes. The connection is replaced if the session expires, so Raw
+// shouldn't be held on to.
+func (s *ZKSession) Raw() *zookeeper.Conn {
+	return s.conn
 }
 
 func (s *ZKSession) ClientId() *zookeeper.ClientId {
 	return s.conn.ClientId()
 }
 
+// ForceExpire ends the session on the server as though it had timed out: its
+// ephemeral nodes are removed and subscribers are notified as for any other
+// expiry. It is a testing and diagnostic aid, for deterministically exercising
+// the code paths that deal with a lost session, and has no place in production
+// code.
+func (s *ZKSession) ForceExpire() error {
+	conn, events, err := zookeeper.Redial(s.servers, s.recvTimeout, s.ClientId())
+	if err != nil {
+		return err
+	}
+
+	// Closing a second connection to the same session closes the session itself.
+	if err := waitForConnection(events, s.connectTimeout); err != nil {
+		conn.Close()
+		return err
+	}
+	return conn.Close()
+}
+
+// SaveClientId serializes the negotiated session ID and password, so that the
+// session can later be resumed with ResumeOrNewZKSession.
+func (s *ZKSession) SaveClientId() ([]byte, error) {
+	return s.ClientId().Save()
+}
+
 func (s *ZKSession) Close() error {
 	return s.conn.Close()
 }
 
 func (s *ZKSession) Create(path string, value string, flags int, aclv []zookeeper.ACL) (string, error) {
-	return s.conn.Create(path, value, flags, aclv)
+	var created string
+	var err error
+	op := func() { created, err = s.conn.Create(path, value, flags, aclv) }
+	// Nobody knows about an ephemeral node whose creation timed out, and a
+	// sequential one would hold up everyone queued behind it until the session
+	// ends, so remove it if the create goes through after all.
+	undo := func() {
+		if err == nil && flags&zookeeper.EPHEMERAL != 0 {
+			s.conn.Delete(created, -1)
+		}
+	}
+	if timeoutErr := s.withTimeoutUndo(op, undo); timeoutErr != nil {
+		return "", timeoutErr
+	}
+	return created, err
 }
 
 func (s *ZKSession) Delete(path string, version int) error {
-	return s.conn.Delete(path, version)
+	var err error
+	if timeoutErr := s.withTimeout(func() { err = s.conn.Delete(path, version) }); timeoutErr != nil {
+		return timeoutErr
+	}
+	if err == nil || zookeeper.IsError(err, zookeeper.ZNONODE) {
+		s.forgetEnsuredPath(path)
+	}
+	return err
 }
 
 func (s *ZKSession) Exists(path string) (*zookeeper.Stat, error) {
-	return s.conn.Exists(path)
+	var stat *zookeeper.Stat
+	var err error
+	if timeoutErr := s.withTimeout(func() { stat, err = s.conn.Exists(path) }); timeoutErr != nil {
+		return nil, timeoutErr
+	}
+	return stat, err
 }
 
 func (s *ZKSession) ExistsW(path string) (*zookeeper.Stat, <-chan zookeeper.Event, error) {
-	return s.conn.ExistsW(path)
+	var stat *zookeeper.Stat
+	var watch <-chan zookeeper.Event
+	var err error
+	if timeoutErr := s.withTimeout(func() { stat, watch, err = s.conn.ExistsW(path) }); timeoutErr != nil {
+		return nil, nil, timeoutErr
+	}
+	return stat, watch, err
 }
 
 func (s *ZKSession) Get(path string) (string, *zookeeper.Stat, error) {
-	return s.conn.Get(path)
+	var value string
+	var stat *zookeeper.Stat
+	var err error
+	if timeoutErr := s.withTimeout(func() { value, stat, err = s.conn.Get(path) }); timeoutErr != nil {
+		return "", nil, timeoutErr
+	}
+	return value, stat, err
 }
 
 func (s *ZKSession) GetW(path string) (string, *zookeeper.Stat, <-chan zookeeper.Event, error) {
-	return s.conn.GetW(path)
+	var value string
+	var stat *zookeeper.Stat
+	var watch <-chan zookeeper.Event
+	var err error
+	if timeoutErr := s.withTimeout(func() { value, stat, watch, err = s.conn.GetW(path) }); timeoutErr != nil {
+		return "", nil, nil, timeoutErr
+	}
+	return value, stat, watch, err
 }
 
 func (s *ZKSession) Set(path string, value string, version int) (*zookeeper.Stat, error) {
-	return s.conn.Set(path, value, version)
+	var stat *zookeeper.Stat
+	var err error
+	if timeoutErr := s.withTimeout(func() { stat, err = s.conn.Set(path, value, version) }); timeoutErr != nil {
+		return nil, timeoutErr
+	}
+	return stat, err
 }
 
 func (s *ZKSession) RetryChange(path string, flags int, acl []zookeeper.ACL, changeFunc zookeeper.ChangeFunc) error {
 	return s.conn.RetryChange(path, flags, acl, changeFunc)
 }
 
+// GetACL returns the ACL of the node at path. The ACL version in the returned
+// stat, stat.AVersion(), can be passed to SetACL to make sure the ACL hasn't
+// changed in the meantime.
+func (s *ZKSession) GetACL(path string) ([]zookeeper.ACL, *zookeeper.Stat, error) {
+	var aclv []zookeeper.ACL
+	var stat *zookeeper.Stat
+	var err error
+	if timeoutErr := s.withTimeout(func() { aclv, stat, err = s.conn.ACL(path) }); timeoutErr != nil {
+		return nil, nil, timeoutErr
+	}
+	return aclv, stat, err
+}
+
+// SetACL replaces the ACL of the node at path, provided its ACL version is
+// still version; -1 matches any version. If the ACL has been changed since,
+// the operation fails with a ZBADVERSION error, and if the session lacks the
+// ADMIN permission on the node, with ZNOAUTH.
 func (s *ZKSession) SetACL(path string, aclv []zookeeper.ACL, version int) error {
-	return s.conn.SetACL(path, aclv, version)
+	var err error
+	if timeoutErr := s.withTimeout(func() { err = s.conn.SetACL(path, aclv, version) }); timeoutErr != nil {
+		return timeoutErr
+	}
+	return err
+}
+
+// withTimeout runs op, returning ErrZKOperationTimeout if it doesn't complete
+// within the operation timeout. Calls into the binding can't be interrupted, so
+// on a timeout op is abandoned to finish in the background; its results must
+// not be read.
+func (s *ZKSession) withTimeout(op func()) error {
+	return s.withTimeoutUndo(op, nil)
+}
+
+// withTimeoutUndo is like withTimeout, but if op times out, undo is called once
+// op has finished in the background, and may read op's results.
+func (s *ZKSession) withTimeoutUndo(op func(), undo func()) error {
+	if s.opTimeout <= 0 {
+		op()
+		return nil
+	}
+
+	done := make(chan struct{})
+	decided := make(chan struct{})
+	timedOut := false
+	go func() {
+		op()
+		close(done)
+		<-decided
+		if timedOut && undo != nil {
+			undo()
+		}
+	}()
+
+	timer := time.NewTimer(s.opTimeout)
+	defer timer.Stop()
+	defer close(decided)
+
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		timedOut = true
+		return ErrZKOperationTimeout
+	}
 }
@@ -151,3 +151,243 @@ func TestResumeZKSessionWithInvalidClientIdDoesNotDisconnectExistingSession(t *t
 		t.Log("Existing session was not disconnected by ResumeZKSession with invalid clientId")
 	}
 }
+
+func TestNewZKSessionWithUnreachableServerTimesOut(t *testing.T) {
+	start := time.Now()
+
+	store, err := NewZKSession("127.0.0.1:1", 200*time.Millisecond, nil, WithConnectTimeout(500*time.Millisecond))
+	if err != ErrZKSessionNotConnected {
+		t.Error("Expected ErrZKSessionNotConnected, but got: ", err)
+	}
+	if store != nil {
+		t.Error("Expected no session to be returned")
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < 500*time.Millisecond || elapsed > 2*time.Second {
+		t.Error("Connect timeout took an unexpected amount of time: ", elapsed)
+	}
+}
+
+func TestResumeOrNewZKSessionResumesSavedSession(t *testing.T) {
+	store, err := NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+
+	saved, err := store.SaveClientId()
+	if err != nil {
+		t.Error("Failed to save clientId: ", err)
+	}
+
+	resumeStore, resumed, err := ResumeOrNewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil, saved)
+	if err != nil {
+		t.Fatal("Failed to resume session with Zookeeper: ", err)
+	}
+	defer resumeStore.Close()
+
+	if !resumed {
+		t.Error("Expected the saved session to be resumed")
+	}
+}
+
+func TestResumeOrNewZKSessionWithInvalidClientIdCreatesNewSession(t *testing.T) {
+	saved, err := invalidClientId(t).Save()
+	if err != nil {
+		t.Error("Failed to save clientId: ", err)
+	}
+
+	store, resumed, err := ResumeOrNewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil, saved)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+	defer store.Close()
+
+	if resumed {
+		t.Error("Expected a new session rather than a resumed one")
+	}
+
+	if _, _, err := store.Children("/"); err != nil {
+		t.Error("Expected the new session to be usable: ", err)
+	}
+}
+
+func TestHeldNodesReflectsTrackedNodes(t *testing.T) {
+	store, err := NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+	defer store.Close()
+
+	store.TrackNode("/test/foo")
+	store.TrackNode("/test/bar")
+	store.UntrackNode("/test/foo")
+
+	held := store.HeldNodes()
+	if len(held) != 1 || held[0] != "/test/bar" {
+		t.Error("Expected only /test/bar to be held, actual: ", held)
+	}
+}
+
+func TestForceExpireExpiresSession(t *testing.T) {
+	store, err := NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+	defer store.Close()
+
+	events := make(chan ZKSessionEvent)
+	store.Subscribe(events)
+
+	if err := store.ForceExpire(); err != nil {
+		t.Fatal("ForceExpire error: ", err)
+	}
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event == SessionExpiredReconnected {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Failed to receive SessionExpiredReconnected")
+		}
+	}
+}
+
+func TestEnsurePathAppliesDefaultACL(t *testing.T) {
+	acl := zookeeper.WorldACL(zookeeper.PERM_READ | zookeeper.PERM_WRITE | zookeeper.PERM_CREATE | zookeeper.PERM_DELETE)
+
+	store, err := NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil, WithDefaultACL(acl))
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+	defer store.Close()
+	store.DeleteRecursive("/test")
+
+	if err := store.EnsurePath("/test/foo"); err != nil {
+		t.Fatal("EnsurePath error: ", err)
+	}
+
+	actual, _, err := store.ACL("/test/foo")
+	if err != nil {
+		t.Fatal("ACL error: ", err)
+	}
+	AssertEqual(t, acl, actual)
+}
+
+func TestSetACLWithStaleVersionFails(t *testing.T) {
+	withTestStore(t, func(session *ZKSession) {
+		initializeZK(t, session, "/test")
+
+		_, stat, err := session.GetACL("/test")
+		if err != nil {
+			t.Fatal("GetACL error: ", err)
+		}
+
+		acl := zookeeper.WorldACL(zookeeper.PERM_ALL ^ zookeeper.PERM_DELETE)
+		if err := session.SetACL("/test", acl, stat.AVersion()); err != nil {
+			t.Fatal("SetACL error: ", err)
+		}
+
+		actual, _, err := session.GetACL("/test")
+		if err != nil {
+			t.Fatal("GetACL error: ", err)
+		}
+		AssertEqual(t, acl, actual)
+
+		err = session.SetACL("/test", zookeeper.WorldACL(zookeeper.PERM_ALL), stat.AVersion())
+		if !zookeeper.IsError(err, zookeeper.ZBADVERSION) {
+			t.Error("Expected ZBADVERSION, but got: ", err)
+		}
+	})
+}
+
+func TestWithTimeoutUndoUndoesOnlyTimedOutOperations(t *testing.T) {
+	s := &ZKSession{opTimeout: 50 * time.Millisecond}
+
+	undone := make(chan struct{}, 1)
+	undo := func() { undone <- struct{}{} }
+
+	err := s.withTimeoutUndo(func() { time.Sleep(100 * time.Millisecond) }, undo)
+	if err != ErrZKOperationTimeout {
+		t.Error("Expected ErrZKOperationTimeout, but got: ", err)
+	}
+	select {
+	case <-undone:
+	case <-time.After(time.Second):
+		t.Error("Expected the timed out operation to be undone")
+	}
+
+	if err := s.withTimeoutUndo(func() {}, undo); err != nil {
+		t.Error("withTimeoutUndo error: ", err)
+	}
+	select {
+	case <-undone:
+		t.Error("Expected the completed operation not to be undone")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDropOnOverflowDropsEventsForFullSubscribersOnly(t *testing.T) {
+	s := &ZKSession{}
+	WithSubscriptionBuffer(1, DropOnOverflow)(s)
+
+	slow := make(chan ZKSessionEvent)
+	fast := make(chan ZKSessionEvent, 10)
+	s.Subscribe(slow)
+	s.Subscribe(fast)
+
+	// The slow subscriber isn't reading: its forwarder holds the first event
+	// and its buffer the second, so the third is dropped.
+	delivered := make(chan struct{})
+	go func() {
+		for _, event := range []ZKSessionEvent{SessionDisconnected, SessionReconnected, SessionDisconnected} {
+			s.notifySubscribers(event)
+			time.Sleep(50 * time.Millisecond)
+		}
+		close(delivered)
+	}()
+
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a slow subscriber not to block delivery")
+	}
+	AssertEqual(t, uint64(1), s.DroppedEvents())
+	AssertEqual(t, 3, len(fast))
+
+	AssertEqual(t, SessionDisconnected, <-slow)
+	AssertEqual(t, SessionReconnected, <-slow)
+
+	s.Unsubscribe(slow)
+	s.Unsubscribe(fast)
+}
+
+func TestUnsubscribeStopsDeliveryOfBufferedEvents(t *testing.T) {
+	s := &ZKSession{}
+	WithSubscriptionBuffer(2, BlockOnOverflow)(s)
+
+	subscriber := make(chan ZKSessionEvent)
+	s.Subscribe(subscriber)
+	s.notifySubscribers(SessionDisconnected)
+	s.notifySubscribers(SessionReconnected)
+
+	unsubscribed := make(chan struct{})
+	go func() {
+		s.Unsubscribe(subscriber)
+		close(unsubscribed)
+	}()
+	select {
+	case <-unsubscribed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Unsubscribe not to wait for the subscriber to read")
+	}
+
+	select {
+	case event := <-subscriber:
+		t.Error("Expected no events after Unsubscribe, got: ", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
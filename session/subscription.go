@@ -0,0 +1,80 @@
+package session
+
+// OverflowPolicy decides what happens to an event for a subscriber whose
+// buffer is full.
+type OverflowPolicy uint
+
+const (
+	// BlockOnOverflow waits for the subscriber to make room, delaying delivery
+	// to every subscriber after it. No events are lost.
+	BlockOnOverflow OverflowPolicy = iota
+	// DropOnOverflow discards the event for that subscriber only, and counts it
+	// in DroppedEvents. The subscriber keeps the events already in its buffer,
+	// so it misses the newest ones; recipes that must see every transition
+	// should not be subscribed under this policy.
+	DropOnOverflow
+)
+
+type subscription struct {
+	out    chan<- ZKSessionEvent
+	buffer chan ZKSessionEvent
+	done   chan struct{}
+	exited chan struct{}
+}
+
+func newSubscription(out chan<- ZKSessionEvent, size int) *subscription {
+	sub := &subscription{out: out}
+	if size > 0 {
+		sub.buffer = make(chan ZKSessionEvent, size)
+		sub.done = make(chan struct{})
+		sub.exited = make(chan struct{})
+		go sub.forward()
+	}
+	return sub
+}
+
+func (sub *subscription) forward() {
+	defer close(sub.exited)
+	for {
+		select {
+		case event := <-sub.buffer:
+			select {
+			case sub.out <- event:
+			case <-sub.done:
+				return
+			}
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+// stop ends the forwarding of buffered events, which may never be read now,
+// and waits for it so that nothing is sent to the subscriber afterwards.
+func (sub *subscription) stop() {
+	if sub.buffer != nil {
+		close(sub.done)
+		<-sub.exited
+	}
+}
+
+// deliver hands the event to the subscriber, returning false if it was
+// dropped.
+func (sub *subscription) deliver(event ZKSessionEvent, policy OverflowPolicy) bool {
+	if sub.buffer == nil {
+		sub.out <- event
+		return true
+	}
+
+	if policy == DropOnOverflow {
+		select {
+		case sub.buffer <- event:
+			return true
+		default:
+			return false
+		}
+	}
+
+	sub.buffer <- event
+	return true
+}
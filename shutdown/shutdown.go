@@ -0,0 +1,102 @@
+package shutdown
+
+/**
+A Coordinator tears down a service's recipes in a defined order, so that, for example, a leader resigns before it
+releases the locks its work depends on, and only then deregisters from discovery. Without that order, clients may keep
+routing to a node that has already given up its work.
+
+Each recipe's cleanup is registered along with a priority. Shutdown runs the cleanups one at a time, lowest priority
+first, in the order they were registered among equal priorities. The recommended ordering is:
+
+(1) PriorityLeadership: resign leadership, so that another node can take over the work.
+(2) PriorityLocks: release locks, now that no work is being done under them.
+(3) PriorityDiscovery: delete the ephemeral nodes other services use to find this one.
+(4) PrioritySession: close the session, which removes any ephemeral nodes that are left.
+**/
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	PriorityLeadership = 100
+	PriorityLocks      = 200
+	PriorityDiscovery  = 300
+	PrioritySession    = 400
+)
+
+// Error is returned by Shutdown when one or more cleanups failed.
+type Error struct {
+	Errors []error
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d cleanups failed: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+type cleanup struct {
+	priority int
+	name     string
+	fn       func(ctx context.Context) error
+}
+
+type byPriority []cleanup
+
+func (c byPriority) Len() int           { return len(c) }
+func (c byPriority) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byPriority) Less(i, j int) bool { return c[i].priority < c[j].priority }
+
+type Coordinator struct {
+	mu       sync.Mutex
+	cleanups []cleanup
+}
+
+func NewCoordinator() *Coordinator {
+	return &Coordinator{}
+}
+
+// Register adds a cleanup to be run by Shutdown. The name identifies it in
+// errors.
+func (c *Coordinator) Register(priority int, name string, fn func(ctx context.Context) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cleanups = append(c.cleanups, cleanup{priority, name, fn})
+}
+
+// Shutdown runs the registered cleanups in order of priority, carrying on past
+// any that fail. Cleanups are expected to respect ctx; once it is done, the
+// remaining cleanups are skipped and each is reported as failed with
+// ctx.Err(). Every cleanup is run at most once, so calling Shutdown again only
+// runs those registered since.
+func (c *Coordinator) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	cleanups := c.cleanups
+	c.cleanups = nil
+	c.mu.Unlock()
+
+	sort.Stable(byPriority(cleanups))
+
+	var errs []error
+	for _, cleanup := range cleanups {
+		err := ctx.Err()
+		if err == nil {
+			err = cleanup.fn(ctx)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", cleanup.name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &Error{errs}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestShutdownRunsCleanupsInOrder(t *testing.T) {
+	c := NewCoordinator()
+
+	var ran []string
+	record := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+
+	c.Register(PrioritySession, "session", record("session"))
+	c.Register(PriorityLocks, "first lock", record("first lock"))
+	c.Register(PriorityLeadership, "election", record("election"))
+	c.Register(PriorityLocks, "second lock", record("second lock"))
+
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatal("Shutdown error: ", err)
+	}
+
+	expected := []string{"election", "first lock", "second lock", "session"}
+	if !reflect.DeepEqual(expected, ran) {
+		t.Errorf("Expected %v, actual %v", expected, ran)
+	}
+}
+
+func TestShutdownCarriesOnPastFailures(t *testing.T) {
+	c := NewCoordinator()
+
+	ranSession := false
+	c.Register(PriorityLocks, "lock", func(context.Context) error { return errors.New("spam") })
+	c.Register(PrioritySession, "session", func(context.Context) error {
+		ranSession = true
+		return nil
+	})
+
+	err := c.Shutdown(context.Background())
+	if shutdownErr, ok := err.(*Error); !ok || len(shutdownErr.Errors) != 1 {
+		t.Error("Expected one failed cleanup, but got: ", err)
+	}
+	if !ranSession {
+		t.Error("Expected the session cleanup to run")
+	}
+}
+
+func TestShutdownSkipsCleanupsAfterDeadline(t *testing.T) {
+	c := NewCoordinator()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Register(PriorityLocks, "lock", func(context.Context) error {
+		cancel()
+		return nil
+	})
+	c.Register(PrioritySession, "session", func(context.Context) error {
+		t.Error("Expected the session cleanup to be skipped")
+		return nil
+	})
+
+	err := c.Shutdown(ctx)
+	if shutdownErr, ok := err.(*Error); !ok || len(shutdownErr.Errors) != 1 {
+		t.Error("Expected one skipped cleanup, but got: ", err)
+	}
+}
@@ -9,7 +9,7 @@ import (
 
 const PROXY_PORT = "27445"
 
-func CreateProxy(t *testing.T) *toxiproxy.Proxy {
+func CreateProxy(t testing.TB) *toxiproxy.Proxy {
 	url := GetToxiProxyURL(t)
 	zks := GetZooKeepers(t)
 	host := GetToxiProxyHost(t)
@@ -22,21 +22,21 @@ func CreateProxy(t *testing.T) *toxiproxy.Proxy {
 	return proxy
 }
 
-func GetToxiProxyURL(t *testing.T) string {
+func GetToxiProxyURL(t testing.TB) string {
 	if os.Getenv("TOXIPROXY_URL") == "" {
 		t.Fatal("TOXIPROXY_URL environment variable must be defined")
 	}
 	return os.Getenv("TOXIPROXY_URL")
 }
 
-func GetToxiProxyHost(t *testing.T) string {
+func GetToxiProxyHost(t testing.TB) string {
 	if os.Getenv("TOXIPROXY_HOST") == "" {
 		t.Fatal("TOXIPROXY_HOST environment variable must be defined")
 	}
 	return os.Getenv("TOXIPROXY_HOST")
 }
 
-func GetZooKeepers(t *testing.T) string {
+func GetZooKeepers(t testing.TB) string {
 	if os.Getenv("ZOOKEEPERS") == "" {
 		t.Fatal("ZOOKEEPERS environment variable must be defined")
 	}
@@ -0,0 +1,126 @@
+package waitgroup
+
+/**
+A wait group is the cluster-wide equivalent of sync.WaitGroup: Wait blocks until every outstanding piece of work has
+been marked done. Work can be counted in two ways, which can be mixed within one group:
+
+- Add and Done adjust a counter kept in {root}/count. This is cheap, but a worker that crashes before calling Done
+  leaves the group waiting forever.
+- AddEphemeral creates an ephemeral sequential node under {root}/tasks, which the returned Task deletes when done. If
+  the worker's session dies, ZooKeeper deletes the node, so a dead worker's work counts as done.
+
+Wait reads the counter and the tasks, setting a watch on each, and returns once the counter is zero and there are no
+tasks left; otherwise it waits for a watch to fire and reads them again.
+**/
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/Shopify/gozk"
+	"github.com/Shopify/gozk-recipes/session"
+)
+
+// ErrNegativeCounter is returned by Add when it would take the counter below
+// zero. The counter is left unchanged.
+var ErrNegativeCounter = errors.New("wait group counter would be negative")
+
+type WaitGroup struct {
+	Session *session.ZKSession
+	root    string
+}
+
+func NewWaitGroup(session *session.ZKSession, root string) (*WaitGroup, error) {
+	if err := session.EnsureRoot(root + "/tasks"); err != nil {
+		return nil, err
+	}
+	_, err := session.Create(root+"/count", "0", 0, session.DefaultACL())
+	if err != nil && !zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
+		return nil, err
+	}
+	return &WaitGroup{session, root}, nil
+}
+
+// Add adds delta, which may be negative, to the counter.
+func (w *WaitGroup) Add(delta int) error {
+	for {
+		value, stat, err := w.Session.Get(w.root + "/count")
+		if err != nil {
+			return err
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+
+		if count+delta < 0 {
+			return ErrNegativeCounter
+		}
+
+		_, err = w.Session.Set(w.root+"/count", strconv.Itoa(count+delta), stat.Version())
+		if !zookeeper.IsError(err, zookeeper.ZBADVERSION) {
+			return err
+		}
+		// Someone else changed the counter in the meantime; try again.
+	}
+}
+
+// Done decrements the counter by one.
+func (w *WaitGroup) Done() error {
+	return w.Add(-1)
+}
+
+// Task is a piece of work tied to the session of the worker doing it.
+type Task struct {
+	session *session.ZKSession
+	path    string
+}
+
+// AddEphemeral registers a piece of work that is done when the returned Task's
+// Done is called, or when this session ends.
+func (w *WaitGroup) AddEphemeral() (*Task, error) {
+	path, err := w.Session.Create(w.root+"/tasks/task-", w.Session.Identity(), zookeeper.EPHEMERAL|zookeeper.SEQUENCE, w.Session.DefaultACL())
+	if err != nil {
+		return nil, err
+	}
+	w.Session.TrackNode(path)
+	return &Task{w.Session, path}, nil
+}
+
+// Done marks the task as done.
+func (t *Task) Done() error {
+	err := t.session.Delete(t.path, -1)
+	if err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+		return err
+	}
+	t.session.UntrackNode(t.path)
+	return nil
+}
+
+// Wait blocks until the counter is zero and every task is done.
+func (w *WaitGroup) Wait() error {
+	for {
+		value, _, countW, err := w.Session.GetW(w.root + "/count")
+		if err != nil {
+			return err
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+
+		tasks, _, tasksW, err := w.Session.ChildrenW(w.root + "/tasks")
+		if err != nil {
+			return err
+		}
+
+		if count == 0 && len(tasks) == 0 {
+			return nil
+		}
+
+		select {
+		case <-countW:
+		case <-tasksW:
+		}
+	}
+}
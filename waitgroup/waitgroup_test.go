@@ -0,0 +1,77 @@
+package waitgroup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/gozk-recipes/session"
+	"github.com/Shopify/gozk-recipes/test"
+)
+
+func newTestWaitGroup(t *testing.T) *WaitGroup {
+	store, err := session.NewZKSession(test.GetZooKeepers(t), 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal("Failed to connect to Zookeeper: ", err)
+	}
+
+	w, err := NewWaitGroup(store, "/test-waitgroup")
+	if err != nil {
+		t.Fatal("NewWaitGroup error: ", err)
+	}
+	return w
+}
+
+func assertWaitReturns(t *testing.T, w *WaitGroup) {
+	done := make(chan error, 1)
+	go func() { done <- w.Wait() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("Wait error: ", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected Wait to return")
+	}
+}
+
+func TestWaitReturnsOnceEveryAddIsDone(t *testing.T) {
+	w := newTestWaitGroup(t)
+	defer w.Session.Close()
+
+	if err := w.Add(2); err != nil {
+		t.Fatal("Add error: ", err)
+	}
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			time.Sleep(100 * time.Millisecond)
+			if err := w.Done(); err != nil {
+				t.Error("Done error: ", err)
+			}
+		}
+	}()
+
+	assertWaitReturns(t, w)
+
+	if err := w.Done(); err != ErrNegativeCounter {
+		t.Error("Expected ErrNegativeCounter, but got: ", err)
+	}
+}
+
+func TestWaitReturnsWhenEphemeralWorkerDies(t *testing.T) {
+	w := newTestWaitGroup(t)
+	defer w.Session.Close()
+
+	worker := newTestWaitGroup(t)
+	if _, err := worker.AddEphemeral(); err != nil {
+		t.Fatal("AddEphemeral error: ", err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		worker.Session.Close()
+	}()
+
+	assertWaitReturns(t, w)
+}